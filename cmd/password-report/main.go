@@ -0,0 +1,47 @@
+// Command password-report prints how many accounts still use a legacy
+// password hash scheme. Hashes are upgraded on the next successful login, so
+// the bcrypt count only goes down as users log in.
+//
+//	go run ./cmd/password-report
+package main
+
+import (
+	"fmt"
+	"gotrack/database"
+	"gotrack/helpers/common"
+	"gotrack/modules/users"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load("config/.env"); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading .env file")
+		os.Exit(1)
+	}
+
+	database.Conn()
+
+	counts, err := users.NewRepository(database.DBConnections).CountPasswordSchemes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error counting password hashes:", err)
+		os.Exit(1)
+	}
+
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+
+	fmt.Printf("%-10s %8s\n", "scheme", "accounts")
+	for _, scheme := range []string{common.SchemeArgon2id, common.SchemeBcrypt, common.SchemeUnknown} {
+		fmt.Printf("%-10s %8d\n", scheme, counts[scheme])
+	}
+	fmt.Printf("%-10s %8d\n", "total", total)
+
+	legacy := counts[common.SchemeBcrypt] + counts[common.SchemeUnknown]
+	if total > 0 {
+		fmt.Printf("\n%d of %d accounts (%.1f%%) are still on a legacy scheme\n", legacy, total, float64(legacy)*100/float64(total))
+	}
+}
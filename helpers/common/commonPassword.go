@@ -1,22 +1,157 @@
 package common
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are self-describing, so the scheme can change without a
+// migration: new hashes use argon2id in the PHC string format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash), legacy bcrypt hashes
+// ($2a$, $2b$, $2y$) are still accepted and upgraded on the next login.
+const (
+	SchemeArgon2id = "argon2id"
+	SchemeBcrypt   = "bcrypt"
+	SchemeUnknown  = "unknown"
+)
+
+// Argon2Params are read from ARGON2_MEMORY (KiB), ARGON2_TIME and
+// ARGON2_THREADS, defaulting to the RFC 9106 second recommendation.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func CurrentArgon2Params() Argon2Params {
+	params := Argon2Params{
+		Memory:  64 * 1024,
+		Time:    3,
+		Threads: 2,
+	}
+
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY"), 10, 32); err == nil && value >= 8*1024 {
+		params.Memory = uint32(value)
+	}
+
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_TIME"), 10, 32); err == nil && value > 0 {
+		params.Time = uint32(value)
+	}
+
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_THREADS"), 10, 8); err == nil && value > 0 {
+		params.Threads = uint8(value)
+	}
+
+	return params
+}
 
 func HashPassword(password string) (hashedPassword string, err error) {
-	byteHashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	params := CurrentArgon2Params()
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err = rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	return string(byteHashed), nil
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
 func CheckPassword(hashedPassword, password string) (matches bool) {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	switch PasswordScheme(hashedPassword) {
+	case SchemeArgon2id:
+		params, salt, key, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	case SchemeBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+		return err == nil
+	}
+
+	return false
+}
+
+// PasswordScheme tells which scheme produced a hash.
+func PasswordScheme(hashedPassword string) string {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$argon2id$"):
+		return SchemeArgon2id
+	case strings.HasPrefix(hashedPassword, "$2a$"),
+		strings.HasPrefix(hashedPassword, "$2b$"),
+		strings.HasPrefix(hashedPassword, "$2y$"):
+		return SchemeBcrypt
+	}
 
+	return SchemeUnknown
+}
+
+// NeedsRehash reports whether a hash should be replaced after the password
+// was verified: legacy schemes and argon2id hashes with outdated parameters.
+func NeedsRehash(hashedPassword string) bool {
+	if PasswordScheme(hashedPassword) != SchemeArgon2id {
+		return true
+	}
+
+	params, _, key, err := decodeArgon2id(hashedPassword)
 	if err != nil {
-		return false
+		return true
+	}
+
+	return params != CurrentArgon2Params() || len(key) != argon2KeyLength
+}
+
+func decodeArgon2id(hashedPassword string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != SchemeArgon2id {
+		err = errors.New("invalid argon2id hash")
+		return
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		err = errors.New("unsupported argon2id version")
+		return
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		err = errors.New("invalid argon2id parameters")
+		return
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return
+	}
+
+	if len(key) == 0 || params.Time == 0 || params.Threads == 0 {
+		err = errors.New("invalid argon2id hash")
 	}
 
-	return true
+	return
 }
@@ -46,6 +46,20 @@ func GenerateErrorResponse(ctx *gin.Context, message string) {
 	)
 }
 
+func GenerateErrorResponseWithStatus(ctx *gin.Context, status int, message string) {
+	ctx.AbortWithStatusJSON(
+		status,
+		GenerateErrorMessage(message),
+	)
+}
+
+func GenerateErrorResponseWithData(ctx *gin.Context, message string, data interface{}) {
+	ctx.AbortWithStatusJSON(
+		http.StatusBadRequest,
+		GenerateErrorMessageWithData(message, data),
+	)
+}
+
 func GenerateSuccessMessage(message string) APIResponse {
 	return APIResponse{
 		Success: true,
@@ -80,3 +94,11 @@ func GenerateErrorMessage(message string) APIResponse {
 		Data:    nil,
 	}
 }
+
+func GenerateErrorMessageWithData(message string, data interface{}) APIResponse {
+	return APIResponse{
+		Success: false,
+		Message: message,
+		Data:    data,
+	}
+}
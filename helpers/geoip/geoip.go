@@ -0,0 +1,99 @@
+package geoip
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipinfo/go/v2/ipinfo"
+)
+
+// Location is where an IP address is registered. HasCoordinates is false
+// when the lookup had no latitude and longitude, e.g. for private addresses.
+type Location struct {
+	Country        string
+	Region         string
+	City           string
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+// Locator resolves IP addresses to locations.
+type Locator interface {
+	Locate(ip string) (Location, error)
+}
+
+// Default is used by the modules. Init sets the ipinfo.io token.
+var Default Locator = NewIPInfoLocator("")
+
+// Init picks up token_ipinfo once the environment is loaded.
+func Init() {
+	Default = NewIPInfoLocator(os.Getenv("token_ipinfo"))
+}
+
+func Locate(ip string) (Location, error) {
+	return Default.Locate(ip)
+}
+
+type ipInfoLocator struct {
+	client *ipinfo.Client
+}
+
+// NewIPInfoLocator looks addresses up at ipinfo.io.
+func NewIPInfoLocator(token string) Locator {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+
+	return &ipInfoLocator{
+		client: ipinfo.NewClient(httpClient, nil, token),
+	}
+}
+
+func (l *ipInfoLocator) Locate(ip string) (location Location, err error) {
+	address := net.ParseIP(ip)
+	if address == nil || address.IsLoopback() || address.IsPrivate() || address.IsUnspecified() {
+		return
+	}
+
+	info, err := l.client.GetIPInfo(address)
+	if err != nil {
+		return
+	}
+
+	location = Location{
+		Country: info.Country,
+		Region:  info.Region,
+		City:    info.City,
+	}
+
+	// loc is "latitude,longitude"
+	if latitude, longitude, found := strings.Cut(info.Location, ","); found {
+		lat, latErr := strconv.ParseFloat(latitude, 64)
+		long, longErr := strconv.ParseFloat(longitude, 64)
+		if latErr == nil && longErr == nil {
+			location.Latitude = lat
+			location.Longitude = long
+			location.HasCoordinates = true
+		}
+	}
+
+	return
+}
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm is the great-circle distance between two locations.
+func DistanceKm(a, b Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLong := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
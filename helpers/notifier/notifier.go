@@ -0,0 +1,140 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text notification, e.g. a password reset mail.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Send(message Message) error
+}
+
+// Default is the notifier used by the modules. It logs messages until Init
+// picks a backend.
+var Default Notifier = LogNotifier{}
+
+// Init picks the backend from NOTIFIER: "smtp", "file" or "log" (default).
+func Init() error {
+	switch os.Getenv("NOTIFIER") {
+	case "smtp":
+		notifier, err := NewSMTPNotifierFromEnv()
+		if err != nil {
+			return err
+		}
+		Default = notifier
+	case "file":
+		path := os.Getenv("NOTIFIER_FILE")
+		if path == "" {
+			return errors.New("NOTIFIER_FILE is required for the file notifier")
+		}
+		Default = &FileNotifier{Path: path}
+	case "", "log":
+		Default = LogNotifier{}
+	default:
+		return fmt.Errorf("unknown notifier %q", os.Getenv("NOTIFIER"))
+	}
+
+	return nil
+}
+
+func Send(message Message) error {
+	return Default.Send(message)
+}
+
+func format(message Message) string {
+	return fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		message.To, message.Subject, time.Now().Format(time.RFC1123Z), message.Body)
+}
+
+// LogNotifier prints messages to the application log. Only meant for local
+// development, since messages may contain secrets such as reset links.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(message Message) error {
+	log.Printf("notification to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileNotifier appends every message to a file, which is handy for tests.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (f *FileNotifier) Send(message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(format(message) + "\r\n")
+	return err
+}
+
+// SMTPNotifier sends mails through an SMTP server, using PLAIN auth when a
+// username is set.
+type SMTPNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPNotifierFromEnv() (*SMTPNotifier, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp notifier")
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return nil, errors.New("SMTP_FROM is required for the smtp notifier")
+	}
+
+	return &SMTPNotifier{
+		Addr:     net.JoinHostPort(host, port),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
+
+func (s *SMTPNotifier) Send(message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return errors.New("invalid message header")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	body := "From: " + s.From + "\r\n" + format(message)
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{message.To}, []byte(body))
+}
@@ -0,0 +1,174 @@
+package oidc_test
+
+import (
+	"gotrack/helpers/oidc"
+	"gotrack/helpers/oidc/oidctest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	clientID    = "gotrack"
+	redirectURL = "http://localhost/api/users/oidc/callback"
+)
+
+// login runs the flow up to the code and returns it with the verifier.
+func login(t *testing.T, server *oidctest.Server, provider *oidc.Provider, nonce string) (code, verifier string) {
+	t.Helper()
+
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL("state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	callback, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	parsed, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Query().Get("state") != "state-1" {
+		t.Fatalf("state = %q, want state-1", parsed.Query().Get("state"))
+	}
+
+	return parsed.Query().Get("code"), verifier
+}
+
+func newProvider(server *oidctest.Server) *oidc.Provider {
+	return oidc.NewProvider(server.URL, clientID, "", redirectURL, []string{"openid", "email"})
+}
+
+func TestFlow(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	server.SetIdentity(map[string]interface{}{
+		"sub":                "user-1",
+		"email":              "ana@example.com",
+		"email_verified":     true,
+		"preferred_username": "ana",
+	})
+	provider := newProvider(server)
+
+	code, verifier := login(t, server, provider, "nonce-1")
+
+	raw, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	claims, err := provider.VerifyIDToken(raw, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	if claims.Subject != "user-1" || claims.Email != "ana@example.com" || !claims.EmailVerified || claims.PreferredUsername != "ana" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// codes are single use
+	if _, err = provider.Exchange(code, verifier); err == nil {
+		t.Error("code redeemed twice")
+	}
+}
+
+func TestAuthCodeURLSendsPKCE(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	provider := newProvider(server)
+
+	authURL, err := provider.AuthCodeURL("state", "nonce", "verifier-verifier-verifier-verifier-verifie")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(authURL, server.URL+"/authorize?") {
+		t.Errorf("authURL = %s", authURL)
+	}
+
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") != oidc.ChallengeS256("verifier-verifier-verifier-verifier-verifie") {
+		t.Errorf("PKCE parameters = %v", query)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	server.SetIdentity(map[string]interface{}{"sub": "user-1"})
+	provider := newProvider(server)
+
+	code, _ := login(t, server, provider, "nonce")
+
+	other, err := oidc.GenerateVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = provider.Exchange(code, other); err == nil {
+		t.Error("code redeemed with the wrong PKCE verifier")
+	}
+}
+
+func TestVerifyIDTokenRejectsNonceMismatch(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	server.SetIdentity(map[string]interface{}{"sub": "user-1"})
+	server.SetNonce("replayed")
+	provider := newProvider(server)
+
+	code, verifier := login(t, server, provider, "nonce-1")
+
+	raw, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = provider.VerifyIDToken(raw, "nonce-1"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("err = %v, want nonce mismatch", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherAudience(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	server.SetIdentity(map[string]interface{}{"sub": "user-1"})
+	server.SetAudience("another-client")
+	provider := newProvider(server)
+
+	code, verifier := login(t, server, provider, "nonce-1")
+
+	raw, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = provider.VerifyIDToken(raw, "nonce-1"); err == nil || !strings.Contains(err.Error(), "audience") {
+		t.Errorf("err = %v, want audience mismatch", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherIssuer(t *testing.T) {
+	server := oidctest.NewServer(t, clientID)
+	server.SetIdentity(map[string]interface{}{"sub": "user-1"})
+	provider := newProvider(server)
+
+	code, verifier := login(t, server, provider, "nonce-1")
+
+	raw, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another provider neither knows the signing key nor the issuer
+	impostor := oidctest.NewServer(t, clientID)
+	other := newProvider(impostor)
+	if _, err = other.VerifyIDToken(raw, "nonce-1"); err == nil {
+		t.Error("token of another provider accepted")
+	}
+}
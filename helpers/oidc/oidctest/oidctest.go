@@ -0,0 +1,259 @@
+// Package oidctest runs a local OpenID Connect provider for tests. It serves
+// discovery, a JWKS and a token endpoint that checks PKCE and signs RS256 ID
+// tokens; Authorize stands in for the user logging in at the provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Server is a mock identity provider. Its URL is the issuer.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string // checked by the token endpoint when set
+	Key          *rsa.PrivateKey
+	KeyID        string
+
+	mu       sync.Mutex
+	identity map[string]interface{} // see SetIdentity
+	audience string
+	nonce    string
+	grants   map[string]grant
+}
+
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// NewServer starts a provider for clientID that is closed with the test.
+func NewServer(t testing.TB, clientID string) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("oidctest: generate key: %v", err)
+	}
+
+	s := &Server{
+		ClientID: clientID,
+		Key:      key,
+		KeyID:    "test-key",
+		identity: map[string]interface{}{},
+		grants:   map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// SetIdentity sets the claims of the user who logs in next.
+func (s *Server) SetIdentity(claims map[string]interface{}) {
+	s.mu.Lock()
+	s.identity = claims
+	s.mu.Unlock()
+}
+
+// SetAudience makes the provider issue ID tokens for another client.
+func (s *Server) SetAudience(audience string) {
+	s.mu.Lock()
+	s.audience = audience
+	s.mu.Unlock()
+}
+
+// SetNonce makes the provider issue ID tokens with a nonce other than the
+// one of the authorization request.
+func (s *Server) SetNonce(nonce string) {
+	s.mu.Lock()
+	s.nonce = nonce
+	s.mu.Unlock()
+}
+
+// Authorize plays the user logging in at the provider: it checks the
+// authorization request in authURL and returns the redirect back to the
+// relying party, carrying a fresh code and the state.
+func (s *Server) Authorize(authURL string) (string, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+
+	switch {
+	case query.Get("response_type") != "code":
+		return "", errors.New("oidctest: response_type must be code")
+	case query.Get("client_id") != s.ClientID:
+		return "", errors.New("oidctest: unknown client_id")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", errors.New("oidctest: S256 code challenge required")
+	case query.Get("redirect_uri") == "":
+		return "", errors.New("oidctest: redirect_uri required")
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.grants[code] = grant{
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+	}
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", err
+	}
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	return redirect.String(), nil
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.Key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.Key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	if clientID != s.ClientID || (s.ClientSecret != "" && secret != s.ClientSecret) {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	s.mu.Lock()
+	code := r.PostForm.Get("code")
+	grant, ok := s.grants[code]
+	// codes are single use, even when the exchange fails
+	delete(s.grants, code)
+	identity, audience, nonce := s.identity, s.audience, s.nonce
+	s.mu.Unlock()
+
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	if audience == "" {
+		audience = s.ClientID
+	}
+	if nonce == "" {
+		nonce = grant.nonce
+	}
+
+	claims := jwt.MapClaims{}
+	for name, value := range identity {
+		claims[name] = value
+	}
+	claims["iss"] = s.URL
+	claims["aud"] = audience
+	claims["nonce"] = nonce
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(5 * time.Minute).Unix()
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = s.KeyID
+
+	signed, err := idToken.SignedString(s.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
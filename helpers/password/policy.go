@@ -0,0 +1,222 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxLength bounds passwords so hashing cannot be abused with huge inputs.
+const MaxLength = 128
+
+// Policy is the set of rules a new password has to follow. Organizations
+// store their own copy, DefaultPolicy applies everywhere else.
+type Policy struct {
+	MinLength        int  `json:"min_length" gorm:"default:8"`
+	RequireUpper     bool `json:"require_upper"`
+	RequireLower     bool `json:"require_lower"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	DisallowUsername bool `json:"disallow_username" gorm:"default:true"`
+	DisallowCommon   bool `json:"disallow_common" gorm:"default:true"`
+}
+
+var DefaultPolicy = Policy{
+	MinLength:        8,
+	DisallowUsername: true,
+	DisallowCommon:   true,
+}
+
+// Violation is one rule a password broke, reported per field so clients can
+// show it next to the right input.
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError carries every violation of a password check.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (p *PolicyError) Error() string {
+	messages := make([]string, 0, len(p.Violations))
+	for _, violation := range p.Violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+//go:embed common.txt
+var commonList string
+
+var commonPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(strings.NewReader(commonList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[line] = struct{}{}
+	}
+
+	return passwords
+}()
+
+// Validate checks a policy configured by an owner.
+func (p Policy) Validate() error {
+	if p.MinLength < DefaultPolicy.MinLength || p.MinLength > MaxLength {
+		return &PolicyError{Violations: []Violation{{
+			Field:   "min_length",
+			Code:    "out_of_range",
+			Message: fmt.Sprintf("min length must be between %d and %d", DefaultPolicy.MinLength, MaxLength),
+		}}}
+	}
+
+	return nil
+}
+
+// Check returns a *PolicyError listing every rule password breaks, or nil.
+func (p Policy) Check(password, username string) error {
+	var violations []Violation
+	add := func(code, message string) {
+		violations = append(violations, Violation{Field: "password", Code: code, Message: message})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add("too_short", fmt.Sprintf("password must contain at least %d characters", p.MinLength))
+	}
+
+	if length > MaxLength {
+		add("too_long", fmt.Sprintf("password must contain at most %d characters", MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		add("missing_upper", "password must contain an uppercase letter")
+	}
+
+	if p.RequireLower && !lower {
+		add("missing_lower", "password must contain a lowercase letter")
+	}
+
+	if p.RequireDigit && !digit {
+		add("missing_digit", "password must contain a digit")
+	}
+
+	if p.RequireSymbol && !symbol {
+		add("missing_symbol", "password must contain a symbol")
+	}
+
+	if p.DisallowUsername && similarToUsername(password, username) {
+		add("similar_to_username", "password must not be similar to the username")
+	}
+
+	if p.DisallowCommon && isCommon(password) {
+		add("too_common", "password is too common, please choose another one")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func normalize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, value)
+}
+
+func reverse(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// similarToUsername catches passwords that contain the username (also
+// reversed) or are only a couple of edits away from it.
+func similarToUsername(password, username string) bool {
+	password, username = normalize(password), normalize(username)
+	if len(username) < 3 || password == "" {
+		return false
+	}
+
+	if strings.Contains(password, username) || strings.Contains(password, reverse(username)) {
+		return true
+	}
+
+	if len(password) >= 3 && strings.Contains(username, password) {
+		return true
+	}
+
+	return distance(password, username) <= 2
+}
+
+func isCommon(password string) bool {
+	lower := strings.ToLower(password)
+	if _, ok := commonPasswords[lower]; ok {
+		return true
+	}
+
+	base := strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	if len(base) < 4 {
+		return false
+	}
+
+	_, ok := commonPasswords[base]
+	return ok
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,254 @@
+package rbac
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	UserCreate        = "user:create"
+	UserRead          = "user:read"
+	UserUpdate        = "user:update" // update other users, including their role
+	UserDelete        = "user:delete"
+	UserTrack         = "user:track"
+	UserReadSession   = "user:session:read"
+	UserRevokeSession = "user:session:revoke"
+	UserUnlock        = "user:unlock"
+	UserImpersonate   = "user:impersonate"
+	UserReadLogin     = "user:login:read"
+
+	OrderCreate   = "order:create"
+	OrderReadAll  = "order:read:all"
+	OrderReadOwn  = "order:read:own"
+	OrderUpdate   = "order:update"
+	OrderDelete   = "order:delete"
+	OrderDeliver  = "order:deliver"
+	OrderComplete = "order:complete"
+
+	RoleManage         = "role:manage"
+	OrganizationManage = "organization:manage"
+	APIKeyManage       = "apikey:manage"
+	AuditRead          = "audit:read"
+)
+
+// AllPermissions lists every permission known to the API.
+var AllPermissions = []string{
+	UserCreate, UserRead, UserUpdate, UserDelete, UserTrack, UserReadSession, UserRevokeSession, UserUnlock, UserImpersonate, UserReadLogin,
+	OrderCreate, OrderReadAll, OrderReadOwn, OrderUpdate, OrderDelete, OrderDeliver, OrderComplete,
+	RoleManage, OrganizationManage, APIKeyManage, AuditRead,
+}
+
+// OwnerRole always holds every permission.
+const OwnerRole = "owner"
+
+// DefaultRoles is seeded into every organization. A default permission is
+// only granted once, so removing it through /api/roles sticks across restarts.
+var DefaultRoles = map[string][]string{
+	OwnerRole:    AllPermissions,
+	"employee":   {OrderCreate, OrderReadOwn, OrderDeliver, OrderComplete},
+	"dispatcher": {OrderCreate, OrderReadAll, OrderUpdate, UserRead},
+	"supervisor": {OrderReadAll, OrderUpdate, OrderDelete, UserRead, UserTrack},
+	"auditor":    {OrderReadAll, UserRead},
+}
+
+type Role struct {
+	gorm.Model
+	OrganizationID uint             `json:"organization_id" gorm:"uniqueIndex:idx_organization_role"`
+	Name           string           `json:"name" gorm:"type:varchar(20);uniqueIndex:idx_organization_role"`
+	Description    string           `json:"description"`
+	Permissions    []RolePermission `json:"permissions" gorm:"foreignKey:RoleID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// RolePermission rows are soft deleted when revoked, which is how seeding
+// knows a default permission was taken away on purpose.
+type RolePermission struct {
+	gorm.Model
+	RoleID     uint   `json:"role_id" gorm:"uniqueIndex:idx_role_permission"`
+	Permission string `json:"permission" gorm:"type:varchar(50);uniqueIndex:idx_role_permission"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// cacheTTL bounds how long a replica keeps serving permissions after they
+// were changed on another replica.
+const cacheTTL = time.Minute
+
+var (
+	mu       sync.RWMutex
+	db       *gorm.DB
+	roles    map[uint]map[string]map[string]bool
+	loadedAt time.Time
+
+	// reloading is held by the one request refreshing a stale cache
+	reloading sync.Mutex
+)
+
+// Init seeds the default roles of every organization and loads the
+// permission cache.
+func Init(database *gorm.DB) error {
+	db = database
+
+	// roles used to be global before organizations existed
+	if db.Migrator().HasIndex(&Role{}, "idx_roles_name") {
+		if err := db.Migrator().DropIndex(&Role{}, "idx_roles_name"); err != nil {
+			return err
+		}
+	}
+
+	var organizationIDs []uint
+	if err := db.Table("organizations").Where("deleted_at IS NULL").Pluck("id", &organizationIDs).Error; err != nil {
+		return err
+	}
+
+	for _, organizationID := range organizationIDs {
+		if err := SeedOrganization(db, organizationID); err != nil {
+			return err
+		}
+	}
+
+	return Reload()
+}
+
+// SeedOrganization creates the default roles of an organization and grants
+// default permissions that were never granted before.
+func SeedOrganization(tx *gorm.DB, organizationID uint) error {
+	for name, permissions := range DefaultRoles {
+		var role Role
+		err := tx.Where(Role{OrganizationID: organizationID, Name: name}).FirstOrCreate(&role).Error
+		if err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			var count int64
+			err = tx.Unscoped().Model(&RolePermission{}).
+				Where("role_id = ? AND permission = ?", role.ID, permission).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 && name != OwnerRole {
+				continue
+			}
+
+			if count > 0 {
+				// owner can never lose a permission
+				err = tx.Unscoped().Model(&RolePermission{}).
+					Where("role_id = ? AND permission = ?", role.ID, permission).
+					Update("deleted_at", nil).Error
+			} else {
+				err = tx.Create(&RolePermission{RoleID: role.ID, Permission: permission}).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reload refreshes the permission cache from the database.
+func Reload() error {
+	var data []Role
+	if err := db.Preload("Permissions").Find(&data).Error; err != nil {
+		return err
+	}
+
+	Load(data)
+
+	return nil
+}
+
+// Load replaces the permission cache with roles, e.g. in tests that run
+// without a database.
+func Load(data []Role) {
+	loaded := make(map[uint]map[string]map[string]bool)
+	for _, role := range data {
+		if loaded[role.OrganizationID] == nil {
+			loaded[role.OrganizationID] = make(map[string]map[string]bool)
+		}
+
+		permissions := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions[permission.Permission] = true
+		}
+		loaded[role.OrganizationID][role.Name] = permissions
+	}
+
+	mu.Lock()
+	roles = loaded
+	loadedAt = time.Now()
+	mu.Unlock()
+}
+
+// snapshot returns the cached permissions. A stale cache is reloaded by one
+// request at a time while the others keep using the old permissions.
+func snapshot() map[uint]map[string]map[string]bool {
+	mu.RLock()
+	current, stale := roles, time.Since(loadedAt) > cacheTTL
+	mu.RUnlock()
+
+	if !stale || db == nil || !reloading.TryLock() {
+		return current
+	}
+	defer reloading.Unlock()
+
+	// another request may have reloaded while this one checked
+	mu.RLock()
+	stale = time.Since(loadedAt) > cacheTTL
+	mu.RUnlock()
+
+	// a failed reload keeps the old permissions and is retried next time
+	if stale {
+		Reload()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return roles
+}
+
+// Can reports whether role has been granted permission in the organization.
+func Can(organizationID uint, role, permission string) bool {
+	return snapshot()[organizationID][role][permission]
+}
+
+// Covers reports whether role holds every permission of other in the
+// organization, i.e. acting as other grants role nothing new.
+func Covers(organizationID uint, role, other string) bool {
+	current := snapshot()[organizationID]
+
+	for permission := range current[other] {
+		if !current[role][permission] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RoleExists reports whether role is defined in the organization.
+func RoleExists(organizationID uint, role string) bool {
+	_, ok := snapshot()[organizationID][role]
+	return ok
+}
+
+// IsPermission reports whether permission is one the API knows about.
+func IsPermission(permission string) bool {
+	for _, known := range AllPermissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+		err   error
+	}{
+		{name: "simple string", input: "+OK\r\n", want: "OK"},
+		{name: "integer", input: ":42\r\n", want: int64(42)},
+		{name: "negative integer", input: ":-2\r\n", want: int64(-2)},
+		{name: "bulk string", input: "$5\r\nhello\r\n", want: "hello"},
+		{name: "bulk string with CRLF", input: "$7\r\nhel\r\nlo\r\n", want: "hel\r\nlo"},
+		{name: "empty bulk string", input: "$0\r\n\r\n", want: ""},
+		{name: "nil bulk string", input: "$-1\r\n", err: ErrNil},
+		{name: "nil array", input: "*-1\r\n", err: ErrNil},
+		{name: "error", input: "-WRONGTYPE Operation against a key\r\n", err: Error("WRONGTYPE Operation against a key")},
+		{name: "array", input: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", want: []interface{}{"a", int64(1), nil}},
+		{name: "empty array", input: "*0\r\n", want: []interface{}{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cn := &conn{reader: bufio.NewReader(strings.NewReader(test.input))}
+
+			got, err := cn.read()
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("err = %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadArrayWithError(t *testing.T) {
+	// e.g. the reply to EXEC, followed by the reply to the next command
+	cn := &conn{reader: bufio.NewReader(strings.NewReader("*3\r\n+OK\r\n-ERR first\r\n-ERR second\r\n+NEXT\r\n"))}
+
+	if _, err := cn.read(); !errors.Is(err, Error("ERR first")) {
+		t.Fatalf("err = %v, want the first error of the array", err)
+	}
+
+	next, err := cn.read()
+	if err != nil || next != "NEXT" {
+		t.Errorf("next reply = %#v, %v, want NEXT: the array was not read to its end", next, err)
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, input := range []string{"OK\r\n", "+OK\n", ":abc\r\n", "$5\r\nhel", "?\r\n"} {
+		cn := &conn{reader: bufio.NewReader(strings.NewReader(input))}
+
+		if _, err := cn.read(); err == nil {
+			t.Errorf("read(%q) returned no error", input)
+		}
+	}
+}
+
+func TestDoEncodesCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		defer server.Close()
+
+		want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nv a l\r\n"
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(server, buf); err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(buf)
+
+		server.Write([]byte("+OK\r\n"))
+	}()
+
+	cn := &conn{Conn: client, reader: bufio.NewReader(client)}
+	reply, err := cn.do(time.Second, "SET", "key", "v a l")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-received; got != "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nv a l\r\n" {
+		t.Errorf("sent %q", got)
+	}
+	if reply != "OK" {
+		t.Errorf("reply = %#v, want OK", reply)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	if got, err := Int64("12", nil); err != nil || got != 12 {
+		t.Errorf("Int64(\"12\") = %d, %v", got, err)
+	}
+	if got, err := String(int64(7), nil); err != nil || got != "7" {
+		t.Errorf("String(7) = %q, %v", got, err)
+	}
+	if got, err := Strings([]interface{}{"a", nil, "b"}, nil); err != nil || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Strings = %v, %v", got, err)
+	}
+	if _, err := String(nil, ErrNil); !errors.Is(err, ErrNil) {
+		t.Errorf("String passed %v instead of ErrNil", err)
+	}
+}
@@ -2,10 +2,21 @@ package main
 
 import (
 	"gotrack/database"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/geoip"
+	"gotrack/helpers/notifier"
+	"gotrack/helpers/oidc"
+	"gotrack/helpers/rbac"
 	"gotrack/helpers/swagger"
+	"gotrack/middlewares"
+	"gotrack/modules/apikeys"
+	"gotrack/modules/auditlogs"
 	"gotrack/modules/orders"
+	"gotrack/modules/organizations"
+	"gotrack/modules/roles"
 	"gotrack/modules/users"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -31,16 +42,81 @@ func main() {
 		panic("Error loading .env file")
 	}
 
+	if err = middlewares.InitKeys(); err != nil {
+		panic("Error loading JWT keys: " + err.Error())
+	}
+
+	if err = middlewares.InitSessionStore(); err != nil {
+		panic("Error connecting to session store: " + err.Error())
+	}
+
+	if err = notifier.Init(); err != nil {
+		panic("Error configuring notifier: " + err.Error())
+	}
+
+	if err = oidc.Init(); err != nil {
+		panic("Error configuring single sign-on: " + err.Error())
+	}
+
+	geoip.Init()
+
 	database.Conn()
 	db := database.DBConnections
 
 	router := gin.Default()
 
-	db.AutoMigrate(&users.User{}, &orders.Order{}, &orders.OrderDetail{}, &users.IPInfo{}, &users.DetailLocation{})
+	if err = configureProxies(router); err != nil {
+		panic("Error configuring trusted proxies: " + err.Error())
+	}
+
+	middlewares.APIKeys = apikeys.NewAuthenticator(apikeys.NewRepository(db))
+	middlewares.Allowlists = organizations.NewAllowlistChecker(db)
+	audit.Init(db)
+
+	db.AutoMigrate(&organizations.Organization{}, &users.User{}, &orders.Order{}, &orders.OrderDetail{}, &orders.OrderHistory{}, &orders.OrderReason{}, &users.IPInfo{}, &users.DetailLocation{}, &users.RefreshToken{}, &users.RecoveryCode{}, &users.PasswordReset{}, &users.Invitation{}, &users.LoginHistory{}, &rbac.Role{}, &rbac.RolePermission{}, &apikeys.APIKey{}, &audit.Log{})
+
+	// behind a load balancer an allowlist would see one address for everyone
+	var hasAllowlist bool
+	if hasAllowlist, err = organizations.HasIPAllowlist(db); err != nil {
+		panic("Error reading IP allowlists: " + err.Error())
+	}
+	if hasAllowlist && !middlewares.ProxiesConfigured() {
+		panic("IP allowlists are configured but TRUSTED_PROXIES and TRUSTED_PLATFORM are not set, see README")
+	}
+
+	if err = organizations.EnsureDefault(db, "users", "orders", "ip_info", "detail_location", "roles"); err != nil {
+		panic("Error creating default organization: " + err.Error())
+	}
+
+	if err = rbac.Init(db); err != nil {
+		panic("Error seeding roles: " + err.Error())
+	}
 
 	swagger.Initiator(router)
 	users.Initiator(router)
 	orders.Initiator(router)
+	roles.Initiator(router)
+	organizations.Initiator(router)
+	apikeys.Initiator(router)
+	auditlogs.Initiator(router)
 
 	router.Run(":" + os.Getenv("PORT"))
 }
+
+// configureProxies decides which forwarding headers ClientIP believes.
+// TRUSTED_PROXIES lists the addresses or CIDRs of our load balancers (none by
+// default, so X-Forwarded-For cannot be spoofed); TRUSTED_PLATFORM names a
+// header set by the platform instead, e.g. CF-Connecting-IP. Deployments
+// behind a load balancer must set one of them, see README.
+func configureProxies(router *gin.Engine) error {
+	router.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM")
+
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return router.SetTrustedProxies(proxies)
+}
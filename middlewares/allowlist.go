@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPAllowlistChecker decides whether a role of an organization may be used
+// from an address.
+type IPAllowlistChecker interface {
+	Allowed(orgID uint, role, ip string) (bool, error)
+}
+
+// Allowlists is registered at startup; every address is allowed while it is
+// nil.
+var Allowlists IPAllowlistChecker
+
+var ErrIPNotAllowed = errors.New("access from this network is not allowed for your role")
+
+// ProxiesConfigured reports whether TRUSTED_PROXIES or TRUSTED_PLATFORM tell
+// ClientIP where the address of the client comes from. Behind a load balancer
+// without either, every request has the address of the load balancer, which
+// defeats allowlists, per-IP login throttling and login anomaly detection.
+func ProxiesConfigured() bool {
+	return os.Getenv("TRUSTED_PROXIES") != "" || os.Getenv("TRUSTED_PLATFORM") != ""
+}
+
+// CheckAllowedIP applies the allowlist of the organization to role and ip.
+func CheckAllowedIP(orgID uint, role, ip string) error {
+	if Allowlists == nil {
+		return nil
+	}
+
+	allowed, err := Allowlists.Allowed(orgID, role, ip)
+	if err != nil {
+		return errors.New("unable to check network allowlist")
+	}
+
+	if !allowed {
+		return ErrIPNotAllowed
+	}
+
+	return nil
+}
+
+// allowedIP is used by JwtMiddleware and by the role and permission checks
+// (which also see API keys), so no route is reachable from networks outside
+// the allowlist.
+func allowedIP(c *gin.Context, loginData UserLoginRedis) bool {
+	err := CheckAllowedIP(loginData.OrganizationID, loginData.Role, c.ClientIP())
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrIPNotAllowed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	c.Abort()
+
+	return false
+}
@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"errors"
+	"gotrack/helpers/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries machine API keys, see APIKeyMiddleware.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves an API key to the session it acts as.
+type APIKeyAuthenticator interface {
+	Authenticate(key, clientIP string) (UserLoginRedis, error)
+}
+
+// APIKeys is registered at startup; API keys are rejected while it is nil.
+var APIKeys APIKeyAuthenticator
+
+// APIKeyMiddleware authenticates requests by their X-API-Key header and stores
+// the same "auth" value as JwtMiddleware, so handlers need no changes.
+func APIKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := authenticateAPIKey(c)
+		if err != nil {
+			common.GenerateErrorResponse(c, err.Error())
+			return
+		}
+
+		c.Set("auth", data)
+
+		c.Next()
+	}
+}
+
+// JwtOrAPIKeyMiddleware uses APIKeyMiddleware when the request carries an
+// X-API-Key header and JwtMiddleware otherwise.
+func JwtOrAPIKeyMiddleware() gin.HandlerFunc {
+	jwtMiddleware := JwtMiddleware()
+	apiKeyMiddleware := APIKeyMiddleware()
+
+	return func(c *gin.Context) {
+		if c.GetHeader(APIKeyHeader) != "" {
+			apiKeyMiddleware(c)
+			return
+		}
+
+		jwtMiddleware(c)
+	}
+}
+
+func authenticateAPIKey(c *gin.Context) (UserLoginRedis, error) {
+	key := c.GetHeader(APIKeyHeader)
+	if key == "" {
+		return UserLoginRedis{}, errors.New("api key required")
+	}
+
+	if APIKeys == nil {
+		return UserLoginRedis{}, errors.New("api keys are not enabled")
+	}
+
+	return APIKeys.Authenticate(key, c.ClientIP())
+}
@@ -0,0 +1,111 @@
+package middlewares
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptStore counts failed attempts (e.g. logins) per key and keeps
+// temporary locks, so throttling holds across replicas when backed by redis.
+type AttemptStore interface {
+	// Fail records a failure and returns the failures seen within window.
+	Fail(key string, window time.Duration) (failures int64, err error)
+	Lock(key string, until time.Time) error
+	LockedUntil(key string) (until time.Time, err error)
+	// Reset clears both the failure counter and the lock.
+	Reset(key string) error
+}
+
+var Attempts AttemptStore = NewMemoryAttemptStore(time.Minute)
+
+type memoryAttempt struct {
+	failures    int64
+	expiredAt   time.Time
+	lockedUntil time.Time
+}
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryAttempt
+}
+
+func NewMemoryAttemptStore(cleanupInterval time.Duration) AttemptStore {
+	store := &memoryAttemptStore{
+		attempts: make(map[string]*memoryAttempt),
+	}
+
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			store.evictExpired()
+		}
+	}()
+
+	return store
+}
+
+func (m *memoryAttemptStore) get(key string) *memoryAttempt {
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt = &memoryAttempt{}
+		m.attempts[key] = attempt
+	}
+
+	return attempt
+}
+
+func (m *memoryAttemptStore) Fail(key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.get(key)
+	if time.Now().After(attempt.expiredAt) {
+		attempt.failures = 0
+		attempt.expiredAt = time.Now().Add(window)
+	}
+	attempt.failures++
+
+	return attempt.failures, nil
+}
+
+func (m *memoryAttemptStore) Lock(key string, until time.Time) error {
+	m.mu.Lock()
+	m.get(key).lockedUntil = until
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *memoryAttemptStore) LockedUntil(key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempt, ok := m.attempts[key]; ok && time.Now().Before(attempt.lockedUntil) {
+		return attempt.lockedUntil, nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (m *memoryAttemptStore) Reset(key string) error {
+	m.mu.Lock()
+	delete(m.attempts, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *memoryAttemptStore) evictExpired() {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, attempt := range m.attempts {
+		if now.After(attempt.expiredAt) && now.After(attempt.lockedUntil) {
+			delete(m.attempts, key)
+		}
+	}
+}
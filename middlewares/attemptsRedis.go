@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"errors"
+	"gotrack/helpers/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	attemptKeyPrefix = "gotrack:attempts:"
+	lockKeyPrefix    = "gotrack:lock:"
+)
+
+type redisAttemptStore struct {
+	client *redis.Client
+}
+
+func NewRedisAttemptStore(client *redis.Client) AttemptStore {
+	return &redisAttemptStore{
+		client: client,
+	}
+}
+
+func (r *redisAttemptStore) Fail(key string, window time.Duration) (int64, error) {
+	failures, err := redis.Int64(r.client.Do("INCR", attemptKeyPrefix+key))
+	if err != nil {
+		return 0, err
+	}
+
+	// the window starts with the first failure
+	if failures == 1 {
+		_, err = r.client.Do("PEXPIRE", attemptKeyPrefix+key, strconv.FormatInt(window.Milliseconds(), 10))
+	}
+
+	return failures, err
+}
+
+func (r *redisAttemptStore) Lock(key string, until time.Time) error {
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := r.client.Do("SET", lockKeyPrefix+key, strconv.FormatInt(until.UnixMilli(), 10), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (r *redisAttemptStore) LockedUntil(key string) (time.Time, error) {
+	until, err := redis.Int64(r.client.Do("GET", lockKeyPrefix+key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return time.UnixMilli(until), nil
+}
+
+func (r *redisAttemptStore) Reset(key string) error {
+	_, err := r.client.Do("DEL", attemptKeyPrefix+key, lockKeyPrefix+key)
+	return err
+}
@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"gotrack/helpers/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEntry starts an audit log entry for the request, with the logged in
+// user (if any) as actor. Impersonated requests also name the impersonator.
+func AuditEntry(c *gin.Context, action string) audit.Log {
+	entry := audit.Log{
+		Action:    action,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	if session, err := GetLoginData(c); err == nil {
+		entry.OrganizationID = session.OrganizationID
+		entry.ActorID = uint(session.UserId)
+		entry.ActorName = session.Username
+		entry.ImpersonatorID = uint(session.ImpersonatorID)
+	}
+
+	return entry
+}
@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"errors"
+	"gotrack/helpers/common"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ChallengeClaims identify a user between two steps of a flow (e.g. the
+// password step and the 2FA step of a login). They are signed like access
+// tokens but use their own audience, so one can never be used as the other.
+// Values carry flow state such as an OIDC state and nonce; they are signed,
+// not encrypted.
+type ChallengeClaims struct {
+	Purpose string            `json:"purpose"`
+	Values  map[string]string `json:"values,omitempty"`
+	jwt.StandardClaims
+}
+
+func challengeAudience(purpose string) string {
+	return jwtAudience() + ":" + purpose
+}
+
+// GenerateChallengeToken issues a short-lived token for purpose.
+func GenerateChallengeToken(userID uint, purpose string, ttl time.Duration, values map[string]string) (token string, claims ChallengeClaims, err error) {
+	tokenID, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return
+	}
+
+	claims = ChallengeClaims{
+		Purpose: purpose,
+		Values:  values,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Issuer:    jwtIssuer(),
+			Audience:  challengeAudience(purpose),
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+
+	token, err = Keys.Sign(claims)
+	return
+}
+
+// ParseChallengeToken verifies a token issued by GenerateChallengeToken for
+// the same purpose and returns the user id it was issued to.
+func ParseChallengeToken(tokenString, purpose string) (userID uint, claims *ChallengeClaims, err error) {
+	claims = &ChallengeClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc)
+	if err != nil || !token.Valid || claims.Purpose != purpose || claims.Id == "" {
+		return 0, nil, errors.New("challenge invalid or expired, please log in again")
+	}
+
+	if !claims.VerifyIssuer(jwtIssuer(), true) || !claims.VerifyAudience(challengeAudience(purpose), true) {
+		return 0, nil, errors.New("challenge invalid or expired, please log in again")
+	}
+
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, nil, errors.New("challenge invalid or expired, please log in again")
+	}
+
+	return uint(id), claims, nil
+}
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxImpersonationTTL bounds an impersonation session; it cannot be
+// refreshed.
+var MaxImpersonationTTL = time.Hour
+
+// ImpersonatorHeader is set on every response to an impersonated session, so
+// clients can show that someone else is acting as the user.
+const ImpersonatorHeader = "X-Impersonator-ID"
+
+// ActorClaim names who really holds an impersonation token, like the "act"
+// claim of RFC 8693.
+type ActorClaim struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+}
+
+// IsImpersonated reports whether the session was issued to someone acting as
+// the user.
+func (u UserLoginRedis) IsImpersonated() bool {
+	return u.ImpersonatorID != 0
+}
+
+func markImpersonation(c *gin.Context, data UserLoginRedis) {
+	if data.IsImpersonated() {
+		c.Header(ImpersonatorHeader, strconv.FormatInt(data.ImpersonatorID, 10))
+	}
+}
+
+// DenyImpersonation keeps impersonated sessions away from account security
+// settings and from starting another impersonation.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loginData, err := GetLoginData(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		if loginData.IsImpersonated() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
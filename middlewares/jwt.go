@@ -2,9 +2,11 @@ package middlewares
 
 import (
 	"errors"
+	"gotrack/helpers/audit"
 	"gotrack/helpers/common"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,10 +14,45 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Claims carries the identity of the session so a token can be verified
+// without the session store (see JWT_STATELESS).
 type Claims struct {
+	OrganizationID uint        `json:"org"`
+	Username       string      `json:"username"`
+	Role           string      `json:"role"`
+	RefreshFamily  string      `json:"fam,omitempty"`
+	AuthTime       int64       `json:"auth_time"`
+	Actor          *ActorClaim `json:"act,omitempty"`
 	jwt.StandardClaims
 }
 
+func (c Claims) Session() (UserLoginRedis, error) {
+	userID, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return UserLoginRedis{}, errors.New("invalid token subject")
+	}
+
+	session := UserLoginRedis{
+		TokenID:        c.Id,
+		UserId:         userID,
+		OrganizationID: c.OrganizationID,
+		Username:       c.Username,
+		Role:           c.Role,
+		RefreshFamily:  c.RefreshFamily,
+		LoginAt:        time.Unix(c.AuthTime, 0),
+		ExpiredAt:      time.Unix(c.ExpiresAt, 0),
+	}
+
+	if c.Actor != nil {
+		if session.ImpersonatorID, err = strconv.ParseInt(c.Actor.Subject, 10, 64); err != nil {
+			return UserLoginRedis{}, errors.New("invalid token actor")
+		}
+		session.ImpersonatorName = c.Actor.Username
+	}
+
+	return session, nil
+}
+
 func JwtMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, err := GetJwtTokenFromHeader(c)
@@ -24,23 +61,131 @@ func JwtMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		data, ok := DummyRedis[tokenString]
-		if !ok {
-			common.GenerateErrorResponse(c, "token invalid, please log in again")
+		// tokens that do not parse belong to no organization, so they are not
+		// audited; only well signed tokens that are no longer accepted are
+		claims, err := ParseJwtToken(tokenString)
+		if err != nil {
+			common.GenerateErrorResponse(c, err.Error())
+			return
+		}
+
+		data, err := claims.Session()
+		if err != nil {
+			common.GenerateErrorResponse(c, err.Error())
 			return
 		}
 
+		if Revocations != nil {
+			revoked, err := Revocations.IsRevoked(claims.Id, data.UserId, data.ImpersonatorID, time.Unix(claims.IssuedAt, 0))
+			if err != nil {
+				common.GenerateErrorResponse(c, "unable to verify token, please try again")
+				return
+			}
+
+			if revoked {
+				auditRejectedToken(c, data, "revoked")
+				common.GenerateErrorResponse(c, "token revoked, please log in again")
+				return
+			}
+		}
+
+		if !isStateless() {
+			session, ok, err := Sessions.Get(claims.Id)
+			if err != nil {
+				common.GenerateErrorResponse(c, "unable to read session, please try again")
+				return
+			}
+
+			if !ok {
+				// the session is gone, so audit with what the token says
+				auditRejectedToken(c, data, "no session")
+				common.GenerateErrorResponse(c, "token invalid, please log in again")
+				return
+			}
+
+			data = session
+		}
+
 		if time.Now().After(data.ExpiredAt) {
 			common.GenerateErrorResponse(c, "token expired, please log in again")
 			return
 		}
 
+		// every authenticated route, not only the permission checked ones,
+		// is reachable from allowed networks only
+		if !allowedIP(c, data) {
+			return
+		}
+
 		c.Set("auth", data)
+		markImpersonation(c, data)
 
 		c.Next()
 	}
 }
 
+// auditRejectedToken records a well signed token that is no longer accepted,
+// e.g. one used after logout.
+func auditRejectedToken(c *gin.Context, data UserLoginRedis, reason string) {
+	entry := AuditEntry(c, audit.TokenRejected)
+	entry.OrganizationID = data.OrganizationID
+	entry.ActorID = uint(data.UserId)
+	entry.ActorName = data.Username
+	entry.TargetType = "token"
+	entry.TargetID = data.TokenID
+
+	audit.Record(entry, map[string]interface{}{"reason": reason})
+}
+
+// isStateless reports whether tokens are trusted on their signature alone,
+// for replicas that do not share the session store.
+func isStateless() bool {
+	return os.Getenv("JWT_STATELESS") == "true"
+}
+
+func jwtIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "gotrack"
+}
+
+func jwtAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return "gotrack-api"
+}
+
+var (
+	errTokenExpired = errors.New("token expired, please log in again")
+	errTokenInvalid = errors.New("token invalid, please log in again")
+)
+
+// ParseJwtToken verifies the signature, expiry, issuer and audience of a token.
+func ParseJwtToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc)
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, errTokenExpired
+		}
+		return nil, errTokenInvalid
+	}
+
+	if !token.Valid || claims.Id == "" {
+		return nil, errTokenInvalid
+	}
+
+	if !claims.VerifyIssuer(jwtIssuer(), true) || !claims.VerifyAudience(jwtAudience(), true) {
+		return nil, errTokenInvalid
+	}
+
+	return claims, nil
+}
+
 func GetJwtTokenFromHeader(c *gin.Context) (tokenString string, err error) {
 	authHeader := c.Request.Header.Get("Authorization")
 
@@ -56,18 +201,46 @@ func GetJwtTokenFromHeader(c *gin.Context) (tokenString string, err error) {
 	return parts[1], nil
 }
 
-func GenerateJwtToken() (token string, err error) {
-	expirationTime := time.Now().Add(2 * time.Hour)
+// AccessTokenTTL is kept short because sessions are extended with refresh
+// tokens instead.
+var AccessTokenTTL = 15 * time.Minute
+
+// maxTokenTTL is the longest an access token can be valid, which is how long
+// a revocation has to be remembered.
+func maxTokenTTL() time.Duration {
+	if MaxImpersonationTTL > AccessTokenTTL {
+		return MaxImpersonationTTL
+	}
+	return AccessTokenTTL
+}
 
+// GenerateJwtToken signs the session into a token. The session TokenID becomes
+// the jti and is what the session store and revocation list are keyed by.
+func GenerateJwtToken(session UserLoginRedis) (token string, err error) {
 	claims := &Claims{
+		OrganizationID: session.OrganizationID,
+		Username:       session.Username,
+		Role:           session.Role,
+		RefreshFamily:  session.RefreshFamily,
+		AuthTime:       session.LoginAt.Unix(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			Id:        session.TokenID,
+			Subject:   strconv.FormatInt(session.UserId, 10),
+			Issuer:    jwtIssuer(),
+			Audience:  jwtAudience(),
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: session.ExpiredAt.Unix(),
 		},
 	}
 
-	generatedTokenJwt := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if session.IsImpersonated() {
+		claims.Actor = &ActorClaim{
+			Subject:  strconv.FormatInt(session.ImpersonatorID, 10),
+			Username: session.ImpersonatorName,
+		}
+	}
 
-	token, err = generatedTokenJwt.SignedString([]byte(os.Getenv("jwt_secret_key")))
+	token, err = Keys.Sign(claims)
 	if err != nil {
 		return
 	}
@@ -100,6 +273,43 @@ func AuthorizeRole(role string) gin.HandlerFunc {
 			return
 		}
 
+		if !allowedIP(c, loginData) {
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// RequirePermission lets the request through when the role of the logged in
+// user (and the scopes of an API key) grant at least one of the given
+// permissions. Like AuthorizeRole it also applies the IP allowlist.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("auth")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		loginData, ok := user.(UserLoginRedis)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data"})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range permissions {
+			if loginData.Can(permission) {
+				if allowedIP(c, loginData) {
+					c.Next()
+				}
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,272 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519, which jwt-go v3 does not ship.
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+type signingMethodEd25519 struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+
+	return nil
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Key is one asymmetric key of the key set. Private is only set for the key
+// tokens are currently signed with.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the signing key and every key tokens may still be verified
+// with, so keys can be rotated without logging everybody out.
+type KeySet struct {
+	signing *Key
+	verify  map[string]*Key
+	secret  []byte
+}
+
+// Keys is the key set used by GenerateJwtToken and JwtMiddleware. Until
+// InitKeys runs it falls back to HS256 with jwt_secret_key.
+var Keys = &KeySet{}
+
+// InitKeys loads JWT_SIGNING_KEY (a PKCS#8/PKCS#1 private key PEM, RSA or
+// Ed25519) and JWT_VERIFY_KEYS (comma separated public key PEM files of keys
+// that were rotated out or belong to other issuers). Without a signing key,
+// tokens stay HS256-signed with jwt_secret_key.
+func InitKeys() error {
+	keys := &KeySet{
+		verify: make(map[string]*Key),
+		secret: []byte(os.Getenv("jwt_secret_key")),
+	}
+
+	if path := os.Getenv("JWT_SIGNING_KEY"); path != "" {
+		key, err := loadPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", path, err)
+		}
+
+		keys.signing = key
+		keys.verify[key.ID] = key
+	}
+
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("verification key %s: %w", path, err)
+		}
+
+		if _, exists := keys.verify[key.ID]; !exists {
+			keys.verify[key.ID] = key
+		}
+	}
+
+	Keys = keys
+	return nil
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.hmacSecret())
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+
+	return token.SignedString(k.signing.Private)
+}
+
+// Keyfunc resolves the verification key from the kid header. Tokens without
+// a kid are only accepted while no asymmetric signing key is configured.
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.signing != nil {
+			return nil, errors.New("missing kid header")
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return k.hmacSecret(), nil
+	}
+
+	key, ok := k.verify[kid]
+	if !ok {
+		return nil, errors.New("unknown kid")
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.Public, nil
+}
+
+func (k *KeySet) hmacSecret() []byte {
+	if k.secret != nil {
+		return k.secret
+	}
+	return []byte(os.Getenv("jwt_secret_key"))
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set.
+func (k *KeySet) JWKS() map[string]interface{} {
+	keys := make([]map[string]string, 0, len(k.verify))
+
+	for _, key := range k.verify {
+		jwk := map[string]string{
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
+
+func loadPrivateKey(path string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.PrivateKey
+	if private, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, errors.New("unsupported private key format")
+		}
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return newKey(jwt.SigningMethodRS256, private, &private.PublicKey)
+	case ed25519.PrivateKey:
+		return newKey(SigningMethodEdDSA, private, private.Public())
+	}
+
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+func loadPublicKey(path string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var public crypto.PublicKey
+	if public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if public, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, errors.New("unsupported public key format")
+		}
+	}
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return newKey(jwt.SigningMethodRS256, nil, public)
+	case ed25519.PublicKey:
+		return newKey(SigningMethodEdDSA, nil, public)
+	}
+
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+// newKey derives the kid from the public key, so every service loading the
+// same PEM agrees on it without extra configuration.
+func newKey(method jwt.SigningMethod, private crypto.PrivateKey, public crypto.PublicKey) (*Key, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return &Key{
+		ID:      base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Method:  method,
+		Private: private,
+		Public:  public,
+	}, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	return block, nil
+}
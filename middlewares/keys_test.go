@@ -0,0 +1,241 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// keyFiles writes the private and public key PEM files of a new key of the
+// given method and returns their paths.
+func keyFiles(t *testing.T, method string) (privatePath, publicPath string) {
+	t.Helper()
+
+	var (
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+	)
+	switch method {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, &key.PublicKey
+	case "EdDSA":
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, pub
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	privatePath = filepath.Join(dir, "private.pem")
+	publicPath = filepath.Join(dir, "public.pem")
+
+	if err = os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+// useKeys runs InitKeys with the signing and verification key files and
+// restores the previous key set after the test.
+func useKeys(t *testing.T, signingKey, verifyKeys string) {
+	t.Helper()
+
+	previous := Keys
+	t.Cleanup(func() { Keys = previous })
+
+	t.Setenv("jwt_secret_key", "test-secret")
+	t.Setenv("JWT_SIGNING_KEY", signingKey)
+	t.Setenv("JWT_VERIFY_KEYS", verifyKeys)
+
+	if err := InitKeys(); err != nil {
+		t.Fatalf("InitKeys: %v", err)
+	}
+}
+
+func testToken(t *testing.T) string {
+	t.Helper()
+
+	token, err := GenerateJwtToken(UserLoginRedis{
+		TokenID:   "key-test",
+		UserId:    1,
+		LoginAt:   time.Now(),
+		ExpiredAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("GenerateJwtToken: %v", err)
+	}
+
+	return token
+}
+
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed.Header
+}
+
+func TestSigningMethods(t *testing.T) {
+	for _, method := range []string{"RS256", "EdDSA"} {
+		t.Run(method, func(t *testing.T) {
+			privatePath, _ := keyFiles(t, method)
+			useKeys(t, privatePath, "")
+
+			token := testToken(t)
+
+			header := tokenHeader(t, token)
+			if header["alg"] != method || header["kid"] != Keys.signing.ID {
+				t.Errorf("header = %v, want alg %s and kid %s", header, method, Keys.signing.ID)
+			}
+
+			claims, err := ParseJwtToken(token)
+			if err != nil {
+				t.Fatalf("ParseJwtToken: %v", err)
+			}
+			if claims.Id != "key-test" || claims.Subject != "1" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	for _, method := range []string{"RS256", "EdDSA"} {
+		t.Run(method, func(t *testing.T) {
+			oldPrivate, oldPublic := keyFiles(t, method)
+			newPrivate, _ := keyFiles(t, method)
+
+			useKeys(t, oldPrivate, "")
+			rotatedOut := testToken(t)
+
+			useKeys(t, "", "")
+			hmac := testToken(t)
+
+			useKeys(t, newPrivate, "")
+			current := testToken(t)
+
+			// signed by a key of the other type that is in no key set
+			other := "RS256"
+			if method == "RS256" {
+				other = "EdDSA"
+			}
+			otherPrivate, _ := keyFiles(t, other)
+			useKeys(t, otherPrivate, "")
+			unknownKey := testToken(t)
+
+			tests := []struct {
+				name       string
+				token      string
+				verifyKeys string
+				valid      bool
+			}{
+				{"current key", current, "", true},
+				{"rotated out key still listed", rotatedOut, oldPublic, true},
+				{"rotated out key dropped", rotatedOut, "", false},
+				{"unknown kid", unknownKey, "", false},
+				{"no kid while a signing key is set", hmac, "", false},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					useKeys(t, newPrivate, test.verifyKeys)
+
+					_, err := ParseJwtToken(test.token)
+					if test.valid && err != nil {
+						t.Errorf("ParseJwtToken: %v", err)
+					}
+					if !test.valid && err == nil {
+						t.Error("token accepted")
+					}
+				})
+			}
+
+			t.Run("algorithm of another key type", func(t *testing.T) {
+				useKeys(t, newPrivate, "")
+
+				// the kid of the current key with the algorithm of the other
+				token := &jwt.Token{
+					Header: map[string]interface{}{"kid": Keys.signing.ID, "alg": other},
+					Method: jwt.GetSigningMethod(other),
+				}
+
+				if _, err := Keys.Keyfunc(token); err == nil {
+					t.Error("key returned for a token of another algorithm")
+				}
+			})
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaPrivate, rsaPublic := keyFiles(t, "RS256")
+	_, edPublic := keyFiles(t, "EdDSA")
+	useKeys(t, rsaPrivate, edPublic+","+rsaPublic)
+
+	jwks := Keys.JWKS()["keys"].([]map[string]string)
+	if len(jwks) != 2 {
+		t.Fatalf("%d keys published, want the signing and the verification key", len(jwks))
+	}
+
+	for _, jwk := range jwks {
+		key, ok := Keys.verify[jwk["kid"]]
+		if !ok {
+			t.Fatalf("unknown kid %q published", jwk["kid"])
+		}
+		if jwk["use"] != "sig" || jwk["alg"] != key.Method.Alg() {
+			t.Errorf("jwk = %v", jwk)
+		}
+		if _, private := jwk["d"]; private {
+			t.Errorf("private key published: %v", jwk)
+		}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			n, _ := base64.RawURLEncoding.DecodeString(jwk["n"])
+			e, _ := base64.RawURLEncoding.DecodeString(jwk["e"])
+			if jwk["kty"] != "RSA" || new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+				t.Errorf("RSA jwk = %v does not match the key", jwk)
+			}
+		case ed25519.PublicKey:
+			x, _ := base64.RawURLEncoding.DecodeString(jwk["x"])
+			if jwk["kty"] != "OKP" || jwk["crv"] != "Ed25519" || !public.Equal(ed25519.PublicKey(x)) {
+				t.Errorf("Ed25519 jwk = %v does not match the key", jwk)
+			}
+		default:
+			t.Errorf("unexpected key type %T", public)
+		}
+	}
+}
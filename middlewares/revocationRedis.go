@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"errors"
+	"gotrack/helpers/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	revokedTokenKeyPrefix = "gotrack:revoked_token:"
+	revokedUserKeyPrefix  = "gotrack:revoked_user:"
+)
+
+type redisRevocationList struct {
+	client *redis.Client
+}
+
+// NewRedisRevocationList shares revocations between replicas. Entries expire
+// on their own once no token they cover can still be valid.
+func NewRedisRevocationList(client *redis.Client) RevocationList {
+	return &redisRevocationList{
+		client: client,
+	}
+}
+
+func (r *redisRevocationList) RevokeToken(tokenID string, until time.Time) error {
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := r.client.Do("SET", revokedTokenKeyPrefix+tokenID, "1", "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (r *redisRevocationList) RevokeUser(userID int64, issuedBefore time.Time) error {
+	ttl := time.Until(issuedBefore.Add(maxTokenTTL())).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := r.client.Do("SET", revokedUserKeyPrefix+strconv.FormatInt(userID, 10),
+		strconv.FormatInt(issuedBefore.Unix(), 10), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (r *redisRevocationList) IsRevoked(tokenID string, userID, impersonatorID int64, issuedAt time.Time) (bool, error) {
+	exists, err := redis.Int64(r.client.Do("EXISTS", revokedTokenKeyPrefix+tokenID))
+	if err != nil {
+		return false, err
+	}
+	if exists == 1 {
+		return true, nil
+	}
+
+	revoked, err := r.isUserRevoked(userID, issuedAt)
+	if err != nil || revoked || impersonatorID == 0 {
+		return revoked, err
+	}
+
+	return r.isUserRevoked(impersonatorID, issuedAt)
+}
+
+func (r *redisRevocationList) isUserRevoked(userID int64, issuedAt time.Time) (bool, error) {
+	before, err := redis.Int64(r.client.Do("GET", revokedUserKeyPrefix+strconv.FormatInt(userID, 10)))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return issuedAt.Unix() <= before, nil
+}
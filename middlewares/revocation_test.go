@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationListImpersonation(t *testing.T) {
+	list := NewMemoryRevocationList(time.Hour).(*memoryRevocationList)
+
+	const impersonator, target = 1, 2
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if err := list.RevokeUser(impersonator, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, _ := list.IsRevoked("own", target, 0, issuedAt); revoked {
+		t.Error("token of the target revoked with the impersonator")
+	}
+	if revoked, _ := list.IsRevoked("impersonation", target, impersonator, issuedAt); !revoked {
+		t.Error("impersonation token outlived the revocation of the impersonator")
+	}
+}
+
+func TestMemoryRevocationListOutlivesImpersonation(t *testing.T) {
+	list := NewMemoryRevocationList(time.Hour).(*memoryRevocationList)
+
+	// revoked after an access token expired but while an impersonation
+	// token issued before it is still valid
+	revokedAt := time.Now().Add(-AccessTokenTTL - time.Minute)
+	issuedAt := revokedAt.Add(-time.Minute)
+
+	if err := list.RevokeUser(1, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	list.evictExpired()
+
+	if revoked, _ := list.IsRevoked("impersonation", 2, 1, issuedAt); !revoked {
+		t.Error("revocation evicted before an impersonation token could expire")
+	}
+}
+
+func TestMemoryRevocationListSameSecond(t *testing.T) {
+	list := NewMemoryRevocationList(time.Hour)
+
+	revokedAt := time.Unix(1_700_000_000, 500_000_000)
+	if err := list.RevokeUser(1, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// iat only has whole seconds, as JwtMiddleware passes it
+	tests := []struct {
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{time.Unix(1_699_999_999, 0), true},
+		{time.Unix(1_700_000_000, 0), true},
+		{time.Unix(1_700_000_001, 0), false},
+	}
+
+	for _, test := range tests {
+		if revoked, _ := list.IsRevoked("token", 1, 0, test.issuedAt); revoked != test.revoked {
+			t.Errorf("IsRevoked(iat %d) = %v, want %v", test.issuedAt.Unix(), revoked, test.revoked)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package middlewares
+
+import (
+	"errors"
+	"gotrack/helpers/rbac"
+	"gotrack/helpers/redis"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserLoginRedis struct {
+	TokenID        string
+	UserId         int64
+	OrganizationID uint
+	Username       string
+	Role           string
+	RefreshFamily  string
+	UserAgent      string
+	ClientIP       string
+	LoginAt        time.Time
+	ExpiredAt      time.Time
+
+	// set for requests authenticated with an API key, whose permissions are
+	// limited to Scopes on top of the role
+	APIKeyID uint
+	Scopes   []string
+
+	// set for impersonation sessions, naming who is acting as the user
+	ImpersonatorID   int64
+	ImpersonatorName string
+}
+
+// Can reports whether the session holds permission through its role and, for
+// API keys, its scopes.
+func (u UserLoginRedis) Can(permission string) bool {
+	if !rbac.Can(u.OrganizationID, u.Role, permission) {
+		return false
+	}
+
+	if u.APIKeyID == 0 {
+		return true
+	}
+
+	for _, scope := range u.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetLoginData returns the session JwtMiddleware stored on the request.
+func GetLoginData(c *gin.Context) (UserLoginRedis, error) {
+	user, exists := c.Get("auth")
+	if !exists {
+		return UserLoginRedis{}, errors.New("user not authenticated")
+	}
+
+	loginData, ok := user.(UserLoginRedis)
+	if !ok {
+		return UserLoginRedis{}, errors.New("invalid user data")
+	}
+
+	return loginData, nil
+}
+
+// SessionStore keeps the sessions issued at login, keyed by the token jti.
+// A session is gone once its ExpiredAt has passed.
+type SessionStore interface {
+	Get(tokenID string) (session UserLoginRedis, found bool, err error)
+	Put(tokenID string, session UserLoginRedis) error
+	Delete(tokenID string) error
+	// DeleteByUser ends the sessions of the user and the impersonation
+	// sessions the user started.
+	DeleteByUser(userID int64) error
+	ListByUser(userID int64) ([]UserLoginRedis, error)
+}
+
+// Sessions is the store used by LoginService and JwtMiddleware.
+var Sessions SessionStore = NewMemorySessionStore(time.Minute)
+
+// InitSessionStore picks the session and attempt backend from SESSION_STORE
+// ("memory" or "redis"). The redis backend is needed as soon as more than one
+// replica runs.
+// REVOCATION_LIST ("memory" or "redis") enables the jti revocation list that
+// stateless replicas rely on.
+func InitSessionStore() error {
+	var client *redis.Client
+
+	redisClient := func() (*redis.Client, error) {
+		if client != nil {
+			return client, nil
+		}
+
+		var err error
+		client, err = NewRedisClientFromEnv()
+		return client, err
+	}
+
+	if os.Getenv("SESSION_STORE") == "redis" {
+		client, err := redisClient()
+		if err != nil {
+			return err
+		}
+
+		Sessions = NewRedisSessionStore(client)
+		Attempts = NewRedisAttemptStore(client)
+	}
+
+	switch os.Getenv("REVOCATION_LIST") {
+	case "memory":
+		Revocations = NewMemoryRevocationList(time.Minute)
+	case "redis":
+		client, err := redisClient()
+		if err != nil {
+			return err
+		}
+
+		Revocations = NewRedisRevocationList(client)
+	}
+
+	return nil
+}
+
+// RevokeSession ends a single session, both in the session store and, when
+// enabled, in the revocation list.
+func RevokeSession(session UserLoginRedis) error {
+	if err := Sessions.Delete(session.TokenID); err != nil {
+		return err
+	}
+
+	if Revocations != nil {
+		return Revocations.RevokeToken(session.TokenID, session.ExpiredAt)
+	}
+
+	return nil
+}
+
+// RevokeSessionFamily ends the access token sessions of one login, i.e. every
+// session issued from the same refresh token family.
+func RevokeSessionFamily(userID int64, familyID string) error {
+	sessions, err := Sessions.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.RefreshFamily != familyID {
+			continue
+		}
+
+		if err = RevokeSession(session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserSessions ends every session of a user, including the
+// impersonation sessions the user started.
+func RevokeUserSessions(userID int64) error {
+	if err := Sessions.DeleteByUser(userID); err != nil {
+		return err
+	}
+
+	if Revocations != nil {
+		return Revocations.RevokeUser(userID, time.Now())
+	}
+
+	return nil
+}
+
+func NewRedisClientFromEnv() (*redis.Client, error) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	db := 0
+	if value := os.Getenv("REDIS_DB"); value != "" {
+		var err error
+		if db, err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+
+	client := redis.NewClient(addr, os.Getenv("REDIS_PASSWORD"), db)
+	if err := client.Ping(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]UserLoginRedis
+}
+
+// NewMemorySessionStore returns a process-local store. Expired sessions are
+// evicted every cleanupInterval.
+func NewMemorySessionStore(cleanupInterval time.Duration) SessionStore {
+	store := &memorySessionStore{
+		sessions: make(map[string]UserLoginRedis),
+	}
+
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			store.evictExpired()
+		}
+	}()
+
+	return store
+}
+
+func (m *memorySessionStore) Get(tokenID string) (UserLoginRedis, bool, error) {
+	m.mu.RLock()
+	session, ok := m.sessions[tokenID]
+	m.mu.RUnlock()
+
+	if !ok || time.Now().After(session.ExpiredAt) {
+		return UserLoginRedis{}, false, nil
+	}
+
+	return session, true, nil
+}
+
+func (m *memorySessionStore) Put(tokenID string, session UserLoginRedis) error {
+	m.mu.Lock()
+	m.sessions[tokenID] = session
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *memorySessionStore) Delete(tokenID string) error {
+	m.mu.Lock()
+	delete(m.sessions, tokenID)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *memorySessionStore) DeleteByUser(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for tokenID, session := range m.sessions {
+		if session.UserId == userID || session.ImpersonatorID == userID {
+			delete(m.sessions, tokenID)
+		}
+	}
+
+	return nil
+}
+
+func (m *memorySessionStore) ListByUser(userID int64) ([]UserLoginRedis, error) {
+	now := time.Now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sessions []UserLoginRedis
+	for _, session := range m.sessions {
+		if session.UserId == userID && now.Before(session.ExpiredAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (m *memorySessionStore) evictExpired() {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for tokenID, session := range m.sessions {
+		if now.After(session.ExpiredAt) {
+			delete(m.sessions, tokenID)
+		}
+	}
+}
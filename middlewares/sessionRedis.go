@@ -0,0 +1,168 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"gotrack/helpers/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	sessionKeyPrefix             = "gotrack:session:"
+	userSessionKeyPrefix         = "gotrack:user_sessions:"
+	impersonatorSessionKeyPrefix = "gotrack:impersonator_sessions:"
+)
+
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+// NewRedisSessionStore stores sessions as JSON values whose redis TTL matches
+// the session ExpiredAt, so redis takes care of eviction. Every user also has
+// a set of their token ids so all of them can be revoked at once, and a set of
+// the impersonation sessions they started.
+func NewRedisSessionStore(client *redis.Client) SessionStore {
+	return &redisSessionStore{
+		client: client,
+	}
+}
+
+func (r *redisSessionStore) Get(tokenID string) (UserLoginRedis, bool, error) {
+	var session UserLoginRedis
+
+	value, err := redis.String(r.client.Do("GET", sessionKeyPrefix+tokenID))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return session, false, nil
+		}
+		return session, false, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &session); err != nil {
+		return session, false, err
+	}
+
+	return session, true, nil
+}
+
+func (r *redisSessionStore) Put(tokenID string, session UserLoginRedis) error {
+	ttl := time.Until(session.ExpiredAt).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Do("SET", sessionKeyPrefix+tokenID, string(value), "PX", strconv.FormatInt(ttl, 10))
+	if err != nil {
+		return err
+	}
+
+	userKey := userSessionKeyPrefix + strconv.FormatInt(session.UserId, 10)
+	if err = r.index(userKey, tokenID, ttl); err != nil {
+		return err
+	}
+
+	if session.IsImpersonated() {
+		impersonatorKey := impersonatorSessionKeyPrefix + strconv.FormatInt(session.ImpersonatorID, 10)
+		err = r.index(impersonatorKey, tokenID, ttl)
+	}
+
+	return err
+}
+
+// index adds tokenID to the set at key, which only has to live as long as
+// the newest session in it.
+func (r *redisSessionStore) index(key, tokenID string, ttl int64) error {
+	if _, err := r.client.Do("SADD", key, tokenID); err != nil {
+		return err
+	}
+
+	pttl, err := redis.Int64(r.client.Do("PTTL", key))
+	if err != nil {
+		return err
+	}
+	if pttl < ttl {
+		_, err = r.client.Do("PEXPIRE", key, strconv.FormatInt(ttl, 10))
+	}
+
+	return err
+}
+
+func (r *redisSessionStore) Delete(tokenID string) error {
+	session, found, err := r.Get(tokenID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = r.client.Do("DEL", sessionKeyPrefix+tokenID); err != nil {
+		return err
+	}
+
+	if found {
+		userKey := userSessionKeyPrefix + strconv.FormatInt(session.UserId, 10)
+		_, err = r.client.Do("SREM", userKey, tokenID)
+	}
+
+	if found && err == nil && session.IsImpersonated() {
+		impersonatorKey := impersonatorSessionKeyPrefix + strconv.FormatInt(session.ImpersonatorID, 10)
+		_, err = r.client.Do("SREM", impersonatorKey, tokenID)
+	}
+
+	return err
+}
+
+func (r *redisSessionStore) DeleteByUser(userID int64) error {
+	keys := []string{"DEL"}
+
+	for _, indexKey := range []string{
+		userSessionKeyPrefix + strconv.FormatInt(userID, 10),
+		impersonatorSessionKeyPrefix + strconv.FormatInt(userID, 10),
+	} {
+		tokenIDs, err := redis.Strings(r.client.Do("SMEMBERS", indexKey))
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, indexKey)
+		for _, tokenID := range tokenIDs {
+			keys = append(keys, sessionKeyPrefix+tokenID)
+		}
+	}
+
+	_, err := r.client.Do(keys...)
+	return err
+}
+
+func (r *redisSessionStore) ListByUser(userID int64) ([]UserLoginRedis, error) {
+	userKey := userSessionKeyPrefix + strconv.FormatInt(userID, 10)
+
+	tokenIDs, err := redis.Strings(r.client.Do("SMEMBERS", userKey))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []UserLoginRedis
+	for _, tokenID := range tokenIDs {
+		session, found, err := r.Get(tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		// expired sessions leave their id behind in the index
+		if !found {
+			if _, err = r.client.Do("SREM", userKey, tokenID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,119 @@
+package middlewares
+
+import (
+	"gotrack/helpers/redis"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testSessionStore runs the same expectations against any SessionStore.
+// User ids are derived from the clock so runs against a shared redis do not
+// see each other's sessions.
+func testSessionStore(t *testing.T, store SessionStore) {
+	base := time.Now().UnixNano() % 1_000_000_000
+	alice, bob := base, base+1
+	expiresAt := time.Now().Add(time.Minute)
+
+	put := func(tokenID string, userID int64, family string, expiredAt time.Time) {
+		t.Helper()
+
+		session := UserLoginRedis{TokenID: tokenID, UserId: userID, RefreshFamily: family, ExpiredAt: expiredAt}
+		if err := store.Put(tokenID, session); err != nil {
+			t.Fatalf("Put(%s): %v", tokenID, err)
+		}
+	}
+
+	tokens := func(userID int64) []string {
+		t.Helper()
+
+		sessions, err := store.ListByUser(userID)
+		if err != nil {
+			t.Fatalf("ListByUser: %v", err)
+		}
+
+		ids := make([]string, 0, len(sessions))
+		for _, session := range sessions {
+			ids = append(ids, session.TokenID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	prefix := "test-" + time.Now().Format("150405.000000000") + "-"
+	put(prefix+"a1", alice, "f1", expiresAt)
+	put(prefix+"a2", alice, "f2", expiresAt)
+	put(prefix+"b1", bob, "f3", expiresAt)
+	// already expired sessions are not stored or not returned
+	put(prefix+"a3", alice, "f4", time.Now().Add(-time.Second))
+
+	session, found, err := store.Get(prefix + "a1")
+	if err != nil || !found {
+		t.Fatalf("Get(a1) = %v, %v", found, err)
+	}
+	if session.UserId != alice || session.RefreshFamily != "f1" || !session.ExpiredAt.Equal(expiresAt) {
+		t.Errorf("Get(a1) = %+v", session)
+	}
+
+	if _, found, err = store.Get(prefix + "a3"); err != nil || found {
+		t.Errorf("Get(expired) = %v, %v, want not found", found, err)
+	}
+	if _, found, err = store.Get(prefix + "missing"); err != nil || found {
+		t.Errorf("Get(missing) = %v, %v, want not found", found, err)
+	}
+
+	if got := tokens(alice); len(got) != 2 || got[0] != prefix+"a1" || got[1] != prefix+"a2" {
+		t.Errorf("ListByUser(alice) = %v", got)
+	}
+
+	if err = store.Delete(prefix + "a1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, found, _ = store.Get(prefix + "a1"); found {
+		t.Error("a1 still found after Delete")
+	}
+	if got := tokens(alice); len(got) != 1 || got[0] != prefix+"a2" {
+		t.Errorf("ListByUser(alice) after Delete = %v", got)
+	}
+
+	// alice acting as bob
+	impersonation := UserLoginRedis{TokenID: prefix + "b2", UserId: bob, ImpersonatorID: alice, ExpiredAt: expiresAt}
+	if err = store.Put(impersonation.TokenID, impersonation); err != nil {
+		t.Fatalf("Put(b2): %v", err)
+	}
+
+	if err = store.DeleteByUser(alice); err != nil {
+		t.Fatalf("DeleteByUser: %v", err)
+	}
+	if got := tokens(alice); len(got) != 0 {
+		t.Errorf("ListByUser(alice) after DeleteByUser = %v", got)
+	}
+	if got := tokens(bob); len(got) != 1 || got[0] != prefix+"b1" {
+		t.Errorf("DeleteByUser(alice) left bob with %v, want only b1", got)
+	}
+
+	store.DeleteByUser(bob)
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore(time.Minute))
+}
+
+// TestRedisSessionStore needs a redis at REDIS_TEST_ADDR, e.g.
+// localhost:6379. It only touches keys of the sessions it creates.
+func TestRedisSessionStore(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	client := redis.NewClient(addr, os.Getenv("REDIS_TEST_PASSWORD"), 0)
+	defer client.Close()
+
+	if err := client.Ping(); err != nil {
+		t.Fatalf("redis not reachable: %v", err)
+	}
+
+	testSessionStore(t, NewRedisSessionStore(client))
+}
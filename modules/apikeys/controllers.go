@@ -0,0 +1,79 @@
+package apikeys
+
+import (
+	"gotrack/database"
+	"gotrack/helpers/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAll godoc
+// @Summary Get all API keys
+// @Description Get the API keys of the organization. The keys themselves are never returned
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Router /api/apikeys [get]
+func GetAll(ctx *gin.Context) {
+	var (
+		apiKeyRepo = NewRepository(database.DBConnections)
+		apiKeySrv  = NewService(apiKeyRepo)
+	)
+
+	data, err := apiKeySrv.GetAll(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get API Key data", int64(len(data)), data)
+}
+
+// Create godoc
+// @Summary Create an API key
+// @Description Creates an API key scoped to the given permissions. The key is only shown in this response
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param apiKey body APIKeyRequest true "API key data"
+// @Security Bearer
+// @Router /api/apikeys [post]
+func Create(ctx *gin.Context) {
+	var (
+		apiKeyRepo = NewRepository(database.DBConnections)
+		apiKeySrv  = NewService(apiKeyRepo)
+	)
+
+	data, err := apiKeySrv.Create(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully added API Key, store it now as it cannot be shown again", data)
+}
+
+// Revoke godoc
+// @Summary Revoke an API key
+// @Description Revokes an API key immediately
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param id path int true "API key ID"
+// @Security Bearer
+// @Router /api/apikeys/{id} [delete]
+func Revoke(ctx *gin.Context) {
+	var (
+		apiKeyRepo = NewRepository(database.DBConnections)
+		apiKeySrv  = NewService(apiKeyRepo)
+	)
+
+	err := apiKeySrv.Revoke(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully revoked API Key")
+}
@@ -0,0 +1,104 @@
+package apikeys
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KeyPrefix starts every API key, so leaked keys are easy to spot in logs and
+// by secret scanners.
+const KeyPrefix = "gtk_"
+
+const (
+	defaultLifetime = 90 * 24 * time.Hour
+	maxLifetime     = 365 * 24 * time.Hour
+)
+
+// APIKey lets an integration call the API without a user login. Only a hash
+// of the key is stored; Prefix identifies it in listings and lookups.
+type APIKey struct {
+	gorm.Model
+	OrganizationID uint       `json:"organization_id" gorm:"index"`
+	CreatedBy      uint       `json:"created_by"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix" gorm:"type:varchar(32);uniqueIndex"`
+	KeyHash        string     `json:"-" gorm:"type:varchar(64)"`
+	Permissions    []string   `json:"permissions" gorm:"serializer:json"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	LastUsedIP     string     `json:"last_used_ip"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+type APIKeyRequest struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"` // defaults to 90 days, at most one year
+}
+
+func (a *APIKeyRequest) ValidateAPIKey() (err error) {
+	if common.IsEmptyField(a.Name) {
+		return errors.New("name required")
+	}
+
+	if len(a.Permissions) == 0 {
+		return errors.New("permissions required")
+	}
+
+	for _, permission := range a.Permissions {
+		if !rbac.IsPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+
+	if a.ExpiresAt != nil {
+		if a.ExpiresAt.Before(time.Now()) {
+			return errors.New("expires at must be in the future")
+		}
+
+		if a.ExpiresAt.After(time.Now().Add(maxLifetime)) {
+			return errors.New("expires at must be within one year")
+		}
+	}
+
+	return
+}
+
+func (a *APIKeyRequest) ConvertToModel(orgID, createdBy uint) APIKey {
+	expiresAt := time.Now().Add(defaultLifetime)
+	if a.ExpiresAt != nil {
+		expiresAt = *a.ExpiresAt
+	}
+
+	seen := make(map[string]bool, len(a.Permissions))
+	permissions := make([]string, 0, len(a.Permissions))
+	for _, permission := range a.Permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return APIKey{
+		OrganizationID: orgID,
+		CreatedBy:      createdBy,
+		Name:           a.Name,
+		Permissions:    permissions,
+		ExpiresAt:      expiresAt,
+	}
+}
+
+// CreatedAPIKey is returned once on creation; Key cannot be shown again.
+type CreatedAPIKey struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
@@ -0,0 +1,76 @@
+package apikeys
+
+import (
+	"gotrack/modules/users"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lastUsedPrecision keeps last-used tracking from writing on every request.
+const lastUsedPrecision = time.Minute
+
+type Repository interface {
+	GetAll(orgID uint) (result []APIKey, err error)
+	Create(key *APIKey) error
+	Revoke(orgID uint, id uint) (bool, error)
+	FindByPrefix(prefix string) (APIKey, error)
+	FindOwner(orgID uint, userID uint) (users.User, error)
+	TouchLastUsed(id uint, ip string) error
+}
+
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(database *gorm.DB) Repository {
+	return &apiKeyRepository{
+		db: database,
+	}
+}
+
+// GetAll implements Repository.
+func (a *apiKeyRepository) GetAll(orgID uint) (result []APIKey, err error) {
+	err = a.db.Where("organization_id = ?", orgID).Order("created_at DESC").Find(&result).Error
+	return
+}
+
+// Create implements Repository.
+func (a *apiKeyRepository) Create(key *APIKey) error {
+	return a.db.Create(key).Error
+}
+
+// Revoke implements Repository. It reports false when the key does not exist
+// in the organization or was already revoked.
+func (a *apiKeyRepository) Revoke(orgID uint, id uint) (bool, error) {
+	result := a.db.Model(&APIKey{}).
+		Where("id = ? AND organization_id = ? AND revoked_at IS NULL", id, orgID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// FindByPrefix implements Repository.
+func (a *apiKeyRepository) FindByPrefix(prefix string) (key APIKey, err error) {
+	err = a.db.Where("prefix = ?", prefix).First(&key).Error
+	return
+}
+
+// FindOwner implements Repository. Keys act as the user who created them, so
+// they stop working once that user is deleted.
+func (a *apiKeyRepository) FindOwner(orgID uint, userID uint) (user users.User, err error) {
+	err = a.db.Where("organization_id = ?", orgID).First(&user, userID).Error
+	return
+}
+
+// TouchLastUsed implements Repository.
+func (a *apiKeyRepository) TouchLastUsed(id uint, ip string) error {
+	now := time.Now()
+
+	return a.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-lastUsedPrecision)).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+}
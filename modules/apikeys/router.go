@@ -0,0 +1,22 @@
+package apikeys
+
+import (
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Initiator(router *gin.Engine) {
+	api := router.Group("/api/apikeys")
+	api.Use(middlewares.JwtMiddleware())
+	api.Use(middlewares.Logging())
+	// keys would outlive the time box of an impersonation
+	api.Use(middlewares.DenyImpersonation())
+	api.Use(middlewares.RequirePermission(rbac.APIKeyManage))
+	{
+		api.GET("", GetAll)
+		api.POST("", Create)
+		api.DELETE(":id", Revoke)
+	}
+}
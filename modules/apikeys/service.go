@@ -0,0 +1,201 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/middlewares"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidKey = errors.New("invalid api key")
+
+type Service interface {
+	GetAll(ctx *gin.Context) (result []APIKey, err error)
+	Create(ctx *gin.Context) (result CreatedAPIKey, err error)
+	Revoke(ctx *gin.Context) (err error)
+}
+
+type apiKeyServices struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return &apiKeyServices{
+		repository,
+	}
+}
+
+// generateKey returns a key like gtk_<12 hex id>_<secret> and its prefix.
+func generateKey() (key, prefix string, err error) {
+	id := make([]byte, 6)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+
+	secret, err := common.GenerateRandomToken(32)
+	if err != nil {
+		return
+	}
+
+	prefix = KeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + secret
+
+	return
+}
+
+// splitKey returns the prefix of a key, which is what keys are looked up by.
+func splitKey(key string) (prefix string, ok bool) {
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return "", false
+	}
+
+	id, secret, found := strings.Cut(strings.TrimPrefix(key, KeyPrefix), "_")
+	if !found || len(id) != 12 || secret == "" {
+		return "", false
+	}
+
+	return KeyPrefix + id, true
+}
+
+// GetAll implements Service.
+func (a *apiKeyServices) GetAll(ctx *gin.Context) (result []APIKey, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.repository.GetAll(loginData.OrganizationID)
+}
+
+// Create implements Service. A key can only be granted permissions its
+// creator holds.
+func (a *apiKeyServices) Create(ctx *gin.Context) (result CreatedAPIKey, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request APIKeyRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		err = errors.New("invalid request")
+		return
+	}
+
+	if err = request.ValidateAPIKey(); err != nil {
+		return
+	}
+
+	for _, permission := range request.Permissions {
+		if !loginData.Can(permission) {
+			err = fmt.Errorf("you cannot grant permission %q", permission)
+			return
+		}
+	}
+
+	key, prefix, err := generateKey()
+	if err != nil {
+		return
+	}
+
+	apiKey := request.ConvertToModel(loginData.OrganizationID, uint(loginData.UserId))
+	apiKey.Prefix = prefix
+	apiKey.KeyHash = common.HashToken(key)
+
+	if err = a.repository.Create(&apiKey); err != nil {
+		return
+	}
+
+	result.Key = key
+	result.APIKey = apiKey
+
+	return
+}
+
+// Revoke implements Service.
+func (a *apiKeyServices) Revoke(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	revoked, err := a.repository.Revoke(loginData.OrganizationID, uint(id))
+	if err != nil {
+		return err
+	}
+
+	if !revoked {
+		return errors.New("api key with given ID does not exist")
+	}
+
+	return nil
+}
+
+// Authenticator resolves X-API-Key headers for middlewares.APIKeyMiddleware.
+type Authenticator struct {
+	repository Repository
+}
+
+func NewAuthenticator(repository Repository) *Authenticator {
+	return &Authenticator{
+		repository: repository,
+	}
+}
+
+// Authenticate implements middlewares.APIKeyAuthenticator. The key acts as
+// its creator with the creator's current role, narrowed to the key scopes.
+func (a *Authenticator) Authenticate(key, clientIP string) (session middlewares.UserLoginRedis, err error) {
+	prefix, ok := splitKey(key)
+	if !ok {
+		return session, errInvalidKey
+	}
+
+	apiKey, err := a.repository.FindByPrefix(prefix)
+	if err != nil {
+		return session, errInvalidKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(common.HashToken(key))) != 1 || apiKey.RevokedAt != nil {
+		return session, errInvalidKey
+	}
+
+	if time.Now().After(apiKey.ExpiresAt) {
+		return session, errors.New("api key expired")
+	}
+
+	owner, err := a.repository.FindOwner(apiKey.OrganizationID, apiKey.CreatedBy)
+	if err != nil {
+		return session, errInvalidKey
+	}
+
+	if err = a.repository.TouchLastUsed(apiKey.ID, clientIP); err != nil {
+		log.Printf("api key %s: update last used: %v", apiKey.Prefix, err)
+	}
+
+	return middlewares.UserLoginRedis{
+		TokenID:        apiKey.Prefix,
+		UserId:         int64(owner.ID),
+		OrganizationID: apiKey.OrganizationID,
+		Username:       owner.Username,
+		Role:           owner.Role,
+		ClientIP:       clientIP,
+		LoginAt:        apiKey.CreatedAt,
+		ExpiredAt:      apiKey.ExpiresAt,
+		APIKeyID:       apiKey.ID,
+		Scopes:         apiKey.Permissions,
+	}, nil
+}
@@ -0,0 +1,71 @@
+package auditlogs
+
+import (
+	"gotrack/database"
+	"gotrack/helpers/common"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAll godoc
+// @Summary Get audit log
+// @Description Get the audit log of the organization, newest first
+// @Tags Audit
+// @Accept json
+// @Produce json
+// @Param actor_id query int false "Actor user ID"
+// @Param action query string false "Action, e.g. login.failure"
+// @Param target_type query string false "Target type, e.g. user"
+// @Param target_id query string false "Target ID"
+// @Param from query string false "From (RFC 3339, inclusive)"
+// @Param to query string false "To (RFC 3339, exclusive)"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page, at most 200"
+// @Security Bearer
+// @Router /api/audit [get]
+func GetAll(ctx *gin.Context) {
+	var (
+		auditLogRepo = NewRepository(database.DBConnections)
+		auditLogSrv  = NewService(auditLogRepo)
+	)
+
+	data, err := auditLogSrv.GetAll(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Audit Log data", int64(len(data)), data)
+}
+
+// Export godoc
+// @Summary Export audit log
+// @Description Download the audit log of the organization as CSV. Takes the same filters as Get audit log, without paging
+// @Tags Audit
+// @Produce text/csv
+// @Param actor_id query int false "Actor user ID"
+// @Param action query string false "Action, e.g. login.failure"
+// @Param target_type query string false "Target type, e.g. user"
+// @Param target_id query string false "Target ID"
+// @Param from query string false "From (RFC 3339, inclusive)"
+// @Param to query string false "To (RFC 3339, exclusive)"
+// @Security Bearer
+// @Router /api/audit/export [get]
+func Export(ctx *gin.Context) {
+	var (
+		auditLogRepo = NewRepository(database.DBConnections)
+		auditLogSrv  = NewService(auditLogRepo)
+	)
+
+	err := auditLogSrv.Export(ctx, ctx.Writer)
+	if err != nil {
+		// once rows are streamed the status is sent and cannot change
+		if ctx.Writer.Written() {
+			log.Printf("audit export: %v", err)
+			return
+		}
+
+		common.GenerateErrorResponse(ctx, err.Error())
+	}
+}
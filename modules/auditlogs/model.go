@@ -0,0 +1,49 @@
+package auditlogs
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Filter narrows audit log queries. Zero values match everything.
+type Filter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+}
+
+// ParseFilter reads actor_id, action, target_type, target_id and the RFC 3339
+// from/to bounds from the query string.
+func ParseFilter(ctx *gin.Context) (filter Filter, err error) {
+	if actorID := ctx.Query("actor_id"); actorID != "" {
+		id, parseErr := strconv.ParseUint(actorID, 10, 64)
+		if parseErr != nil {
+			return filter, errors.New("invalid actor_id")
+		}
+		filter.ActorID = uint(id)
+	}
+
+	if from := ctx.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, errors.New("invalid from, use RFC 3339")
+		}
+	}
+
+	if to := ctx.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, errors.New("invalid to, use RFC 3339")
+		}
+	}
+
+	filter.Action = ctx.Query("action")
+	filter.TargetType = ctx.Query("target_type")
+	filter.TargetID = ctx.Query("target_id")
+
+	return filter, nil
+}
@@ -0,0 +1,83 @@
+package auditlogs
+
+import (
+	"gotrack/helpers/audit"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	GetAll(orgID uint, filter Filter, page int, limit int) (result []audit.Log, err error)
+	Each(orgID uint, filter Filter, fn func(entry audit.Log) error) error
+}
+
+type auditLogRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(database *gorm.DB) Repository {
+	return &auditLogRepository{
+		db: database,
+	}
+}
+
+func (a *auditLogRepository) query(orgID uint, filter Filter) *gorm.DB {
+	query := a.db.Model(&audit.Log{}).Where("organization_id = ?", orgID)
+
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	return query.Order("created_at DESC, id DESC")
+}
+
+// GetAll implements Repository.
+func (a *auditLogRepository) GetAll(orgID uint, filter Filter, page int, limit int) (result []audit.Log, err error) {
+	query := a.query(orgID, filter)
+
+	if page > 0 && limit > 0 {
+		offset := (page - 1) * limit
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	err = query.Find(&result).Error
+	return
+}
+
+// Each implements Repository. Rows are streamed, so exports do not have to
+// fit in memory.
+func (a *auditLogRepository) Each(orgID uint, filter Filter, fn func(entry audit.Log) error) error {
+	rows, err := a.query(orgID, filter).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry audit.Log
+		if err = a.db.ScanRows(rows, &entry); err != nil {
+			return err
+		}
+
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,19 @@
+package auditlogs
+
+import (
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Initiator(router *gin.Engine) {
+	api := router.Group("/api/audit")
+	api.Use(middlewares.JwtMiddleware())
+	api.Use(middlewares.Logging())
+	api.Use(middlewares.RequirePermission(rbac.AuditRead))
+	{
+		api.GET("", GetAll)
+		api.GET("export", Export)
+	}
+}
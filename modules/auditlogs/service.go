@@ -0,0 +1,111 @@
+package auditlogs
+
+import (
+	"encoding/csv"
+	"gotrack/helpers/audit"
+	"gotrack/middlewares"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLimit caps the page size of GetAll; use the export for more.
+const maxLimit = 200
+
+var csvHeader = []string{
+	"id", "created_at", "actor_id", "actor_name", "impersonator_id", "action",
+	"target_type", "target_id", "ip", "user_agent", "details",
+}
+
+type Service interface {
+	GetAll(ctx *gin.Context) (result []audit.Log, err error)
+	Export(ctx *gin.Context, w io.Writer) (err error)
+}
+
+type auditLogServices struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return &auditLogServices{
+		repository,
+	}
+}
+
+// GetAll implements Service.
+func (a *auditLogServices) GetAll(ctx *gin.Context) (result []audit.Log, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := ParseFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return a.repository.GetAll(loginData.OrganizationID, filter, page, limit)
+}
+
+// csvCell keeps spreadsheets from evaluating a cell as a formula. Usernames
+// and user agents of failed logins are chosen by whoever tried to log in.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// Export implements Service. It writes every matching entry as CSV; the
+// download headers are only set once the request has been validated.
+func (a *auditLogServices) Export(ctx *gin.Context, w io.Writer) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="audit-log.csv"`)
+
+	writer := csv.NewWriter(w)
+	if err = writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	err = a.repository.Each(loginData.OrganizationID, filter, func(entry audit.Log) error {
+		return writer.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(entry.ActorID), 10),
+			csvCell(entry.ActorName),
+			strconv.FormatUint(uint64(entry.ImpersonatorID), 10),
+			entry.Action,
+			entry.TargetType,
+			csvCell(entry.TargetID),
+			entry.IP,
+			csvCell(entry.UserAgent),
+			csvCell(entry.Details),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -1,8 +1,10 @@
 package orders
 
 import (
+	"errors"
 	"gotrack/database"
 	"gotrack/helpers/common"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -78,6 +80,7 @@ func GetByID(ctx *gin.Context) {
 		return
 	}
 
+	ctx.Header("ETag", ETag(data.Version))
 	common.GenerateSuccessResponseWithData(ctx, "successfully Get Order data", data)
 }
 
@@ -88,6 +91,7 @@ func GetByID(ctx *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "Order ID"
+// @Param If-Match header string false "ETag of GET /api/order/{id}, or send the version field"
 // @Param order body OrderRequestSwag true "Order data"
 // @Security Bearer
 // @Router /api/order/{id} [put]
@@ -99,13 +103,117 @@ func Update(ctx *gin.Context) {
 
 	err := orderSrv.Update(ctx)
 	if err != nil {
-		common.GenerateErrorResponse(ctx, err.Error())
+		errorResponse(ctx, err)
 		return
 	}
 
 	common.GenerateSuccessResponse(ctx, "successfully updated Order data")
 }
 
+// Patch godoc
+// @Summary Patch an order
+// @Description Changes only the fields sent; the status changes through the order actions
+// @Tags Orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param If-Match header string false "ETag of GET /api/order/{id}, or send the version field"
+// @Param order body OrderPatchRequest true "Order fields"
+// @Security Bearer
+// @Router /api/order/{id} [patch]
+func Patch(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.Patch(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Order data")
+}
+
+// CreateDetail godoc
+// @Summary Add an order detail
+// @Description Adds a line item to an order
+// @Tags Orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param If-Match header string false "ETag of GET /api/order/{id}, or send the version field"
+// @Param detail body OrderDetailRequest true "Line item"
+// @Security Bearer
+// @Router /api/order/{id}/details [post]
+func CreateDetail(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	data, err := orderSrv.CreateDetail(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully added Order Detail data", data)
+}
+
+// UpdateDetail godoc
+// @Summary Update an order detail
+// @Description Changes the fields sent of one line item, which keeps its id
+// @Tags Orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param detailId path int true "Order Detail ID"
+// @Param If-Match header string false "ETag of GET /api/order/{id}, or send the version field"
+// @Param detail body OrderDetailRequest true "Line item fields"
+// @Security Bearer
+// @Router /api/order/{id}/details/{detailId} [patch]
+func UpdateDetail(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.UpdateDetail(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Order Detail data")
+}
+
+// DeleteDetail godoc
+// @Summary Delete an order detail
+// @Description Removes one line item from an order
+// @Tags Orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param detailId path int true "Order Detail ID"
+// @Param If-Match header string true "ETag of GET /api/order/{id}"
+// @Security Bearer
+// @Router /api/order/{id}/details/{detailId} [delete]
+func DeleteDetail(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.DeleteDetail(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully delete order detail")
+}
+
 // Delete godoc
 // @Tags Orders
 // @Summary Delete a order by ID
@@ -147,7 +255,7 @@ func Delivery(ctx *gin.Context) {
 
 	err := orderSrv.Delivery(ctx)
 	if err != nil {
-		common.GenerateErrorResponse(ctx, err.Error())
+		errorResponse(ctx, err)
 		return
 	}
 
@@ -172,9 +280,206 @@ func Success(ctx *gin.Context) {
 
 	err := orderSrv.Success(ctx)
 	if err != nil {
-		common.GenerateErrorResponse(ctx, err.Error())
+		errorResponse(ctx, err)
 		return
 	}
 
 	common.GenerateSuccessResponse(ctx, "data is successfully")
 }
+
+// History godoc
+// @Summary Order history
+// @Description Get every status change of an order, oldest first, with who made it
+// @Tags Orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Security Bearer
+// @Router /api/order/{id}/history [get]
+func History(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	data, err := orderSrv.History(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Order History data", int64(len(data)), data)
+}
+
+// Cancel godoc
+// @Summary Cancel order
+// @Description Cancel a pending or delivering order with a reason code, see /api/order/reasons
+// @Tags Delivery
+// @Accept json,multipart/form-data
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param reason formData string true "Reason code"
+// @Param note formData string false "Note"
+// @Param file formData file false "Photo"
+// @Security Bearer
+// @Router /api/order/cancel/{id} [post]
+func Cancel(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.Cancel(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully cancelled order")
+}
+
+// Fail godoc
+// @Summary Delivery failed
+// @Description Mark a delivering order as failed with a reason code, see /api/order/reasons
+// @Tags Delivery
+// @Accept json,multipart/form-data
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param reason formData string true "Reason code"
+// @Param note formData string false "Note"
+// @Param file formData file false "Photo"
+// @Security Bearer
+// @Router /api/order/fail/{id} [post]
+func Fail(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.Fail(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully marked order as failed")
+}
+
+// Return godoc
+// @Summary Return order
+// @Description Return a delivering or failed order to the sender with a reason code, see /api/order/reasons
+// @Tags Delivery
+// @Accept json,multipart/form-data
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param reason formData string true "Reason code"
+// @Param note formData string false "Note"
+// @Param file formData file false "Photo"
+// @Security Bearer
+// @Router /api/order/return/{id} [post]
+func Return(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.Return(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully returned order")
+}
+
+// Retry godoc
+// @Summary Retry failed order
+// @Description Put a failed order back to Pending, optionally rescheduled or assigned to another employee
+// @Tags Delivery
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param retry body RetryRequest false "Reschedule"
+// @Security Bearer
+// @Router /api/order/retry/{id} [post]
+func Retry(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.Retry(ctx)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully rescheduled order")
+}
+
+// GetReasons godoc
+// @Summary Order reason codes
+// @Description Get the reason codes of the Cancelled, Failed and Returned statuses
+// @Tags Orders
+// @Produce json
+// @Security Bearer
+// @Router /api/order/reasons [get]
+func GetReasons(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	data, err := orderSrv.GetReasons(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully Get Order Reason data", data)
+}
+
+// UpdateReasons godoc
+// @Summary Update order reason codes
+// @Description Replace the reason codes of one status; an empty list restores the defaults
+// @Tags Orders
+// @Accept json
+// @Produce json
+// @Param status path string true "Cancelled, Failed or Returned"
+// @Param reasons body ReasonsRequest true "Reason codes"
+// @Security Bearer
+// @Router /api/order/reasons/{status} [put]
+func UpdateReasons(ctx *gin.Context) {
+	var (
+		orderRepo = NewRepository(database.DBConnections)
+		orderSrv  = NewService(orderRepo)
+	)
+
+	err := orderSrv.UpdateReasons(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Order Reason data")
+}
+
+// errorResponse answers 409 Conflict when the order was not in a status the
+// action applies to, typically because another request moved it first, and
+// 412 or 428 when an edit names a stale or no version.
+func errorResponse(ctx *gin.Context, err error) {
+	var conflict *ConflictError
+
+	switch {
+	case errors.As(err, &conflict):
+		common.GenerateErrorResponseWithStatus(ctx, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, ErrVersionMismatch):
+		common.GenerateErrorResponseWithStatus(ctx, http.StatusPreconditionFailed, err.Error())
+		return
+	case errors.Is(err, ErrVersionRequired):
+		common.GenerateErrorResponseWithStatus(ctx, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+
+	common.GenerateErrorResponse(ctx, err.Error())
+}
@@ -0,0 +1,240 @@
+package orders
+
+import (
+	"errors"
+	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OrderPatchRequest changes only the fields that are sent.
+type OrderPatchRequest struct {
+	Version     int     `json:"version"` // used when there is no If-Match header
+	EmployeeID  *int    `json:"employee_id"`
+	Customer    *string `json:"customer"`
+	Location    *string `json:"location"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+}
+
+func (r *OrderPatchRequest) ConvertToChanges() map[string]interface{} {
+	changes := map[string]interface{}{}
+
+	if r.EmployeeID != nil {
+		changes["employee_id"] = *r.EmployeeID
+	}
+	if r.Customer != nil {
+		changes["customer"] = *r.Customer
+	}
+	if r.Location != nil {
+		changes["location"] = *r.Location
+	}
+	if r.Description != nil {
+		changes["description"] = *r.Description
+	}
+
+	return changes
+}
+
+// OrderDetailRequest adds a line item, or changes the fields sent of one.
+type OrderDetailRequest struct {
+	Version int     `json:"version"` // used when there is no If-Match header
+	Item    *string `json:"item"`
+	Qty     *int    `json:"qty"`
+}
+
+func (r *OrderDetailRequest) ValidateDetail(create bool) error {
+	if r.Item != nil {
+		*r.Item = strings.TrimSpace(*r.Item)
+	}
+
+	if (create || r.Item != nil) && (r.Item == nil || *r.Item == "") {
+		return errors.New("item required")
+	}
+
+	if (create || r.Qty != nil) && (r.Qty == nil || *r.Qty <= 0) {
+		return errors.New("qty required")
+	}
+
+	return nil
+}
+
+func (r *OrderDetailRequest) ConvertToChanges() map[string]interface{} {
+	changes := map[string]interface{}{}
+
+	if r.Item != nil {
+		changes["item"] = *r.Item
+	}
+	if r.Qty != nil {
+		changes["qty"] = *r.Qty
+	}
+
+	return changes
+}
+
+// Patch implements Service.
+func (o *orderServices) Patch(ctx *gin.Context) error {
+	existing, loginData, err := o.loadOrder(ctx)
+	if err != nil {
+		return err
+	}
+
+	var request OrderPatchRequest
+	if err = ctx.ShouldBindJSON(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	version, err := checkVersion(ctx, existing, request.Version)
+	if err != nil {
+		return err
+	}
+
+	if request.Status != nil && *request.Status != existing.Status {
+		return errors.New("status can only be changed through the order actions")
+	}
+
+	if request.EmployeeID != nil && *request.EmployeeID != existing.EmployeeID {
+		employee, err := o.repository.FindEmployee(loginData.OrganizationID, *request.EmployeeID)
+		if err != nil {
+			return errors.New("employee not found")
+		}
+
+		if !rbac.Can(loginData.OrganizationID, employee.Role, rbac.OrderDeliver) {
+			return errors.New("this user can not deliver orders")
+		}
+	}
+
+	if request.Customer != nil && common.IsEmptyField(*request.Customer) {
+		return errors.New("customer required")
+	}
+
+	if request.Location != nil && common.IsEmptyField(*request.Location) {
+		return errors.New("location required")
+	}
+
+	// an empty patch would only bump the version and break everybody's If-Match
+	changes := request.ConvertToChanges()
+	if len(changes) == 0 {
+		return errors.New("nothing to update")
+	}
+
+	if err = o.repository.Patch(loginData.OrganizationID, int(existing.ID), version, changes); err != nil {
+		return err
+	}
+
+	ctx.Header("ETag", ETag(version+1))
+
+	return nil
+}
+
+// detailID reads the :detailId path parameter.
+func detailID(ctx *gin.Context) (int, error) {
+	id, err := strconv.Atoi(ctx.Param("detailId"))
+	if err != nil {
+		return 0, errors.New("invalid detail ID format")
+	}
+
+	return id, nil
+}
+
+// CreateDetail implements Service.
+func (o *orderServices) CreateDetail(ctx *gin.Context) (result OrderDetail, err error) {
+	existing, loginData, err := o.loadOrder(ctx)
+	if err != nil {
+		return
+	}
+
+	var request OrderDetailRequest
+	if err = ctx.ShouldBindJSON(&request); err != nil {
+		err = errors.New("invalid request")
+		return
+	}
+
+	version, err := checkVersion(ctx, existing, request.Version)
+	if err != nil {
+		return
+	}
+
+	if err = request.ValidateDetail(true); err != nil {
+		return
+	}
+
+	result = OrderDetail{Item: *request.Item, Qty: *request.Qty}
+	if err = o.repository.CreateDetail(loginData.OrganizationID, int(existing.ID), version, &result); err != nil {
+		return
+	}
+
+	ctx.Header("ETag", ETag(version+1))
+
+	return
+}
+
+// UpdateDetail implements Service.
+func (o *orderServices) UpdateDetail(ctx *gin.Context) error {
+	existing, loginData, err := o.loadOrder(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := detailID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var request OrderDetailRequest
+	if err = ctx.ShouldBindJSON(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	version, err := checkVersion(ctx, existing, request.Version)
+	if err != nil {
+		return err
+	}
+
+	if err = request.ValidateDetail(false); err != nil {
+		return err
+	}
+
+	changes := request.ConvertToChanges()
+	if len(changes) == 0 {
+		return errors.New("nothing to update")
+	}
+
+	if err = o.repository.UpdateDetail(loginData.OrganizationID, int(existing.ID), version, id, changes); err != nil {
+		return err
+	}
+
+	ctx.Header("ETag", ETag(version+1))
+
+	return nil
+}
+
+// DeleteDetail implements Service. The version comes from If-Match only, as
+// DELETE has no body.
+func (o *orderServices) DeleteDetail(ctx *gin.Context) error {
+	existing, loginData, err := o.loadOrder(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := detailID(ctx)
+	if err != nil {
+		return err
+	}
+
+	version, err := checkVersion(ctx, existing, 0)
+	if err != nil {
+		return err
+	}
+
+	if err = o.repository.DeleteDetail(loginData.OrganizationID, int(existing.ID), version, id); err != nil {
+		return err
+	}
+
+	ctx.Header("ETag", ETag(version+1))
+
+	return nil
+}
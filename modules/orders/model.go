@@ -5,20 +5,26 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/middlewares"
 	"gotrack/modules/users"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type Order struct {
 	gorm.Model
-	EmployeeID  int    `json:"employee_id" gorm:"column:employee_id"`
-	Customer    string `json:"customer"`
-	Location    string `json:"location"`
-	Status      string `json:"status"` // "pending" or "completed"
-	Description string `json:"description"`
+	OrganizationID uint       `json:"organization_id" gorm:"index"`
+	EmployeeID     int        `json:"employee_id" gorm:"column:employee_id"`
+	Customer       string     `json:"customer"`
+	Location       string     `json:"location"`
+	Status         string     `json:"status"` // changed through the state machine only, see transitions
+	Description    string     `json:"description"`
+	ScheduledAt    *time.Time `json:"scheduled_at"`                      // set when a failed delivery is rescheduled
+	Version        int        `json:"version" gorm:"not null;default:1"` // bumped by every change, sent as ETag
 
 	Employee       users.User            `gorm:"foreignKey:EmployeeID; references:ID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	OrderDetails   []OrderDetail         `json:"order_details" gorm:"foreignKey:OrderID"`
@@ -36,13 +42,14 @@ func (o Order) MarshalJSON() ([]byte, error) {
 	}{
 		Alias: (*Alias)(&o),
 	}
-	if o.Status != "Success" {
+	if o.Status != StatusSuccess {
 		alias.DetailLocation = nil
 	}
 	return json.Marshal(alias)
 }
 
 type OrderRequestSwag struct {
+	Version      int               `json:"version"`
 	EmployeeID   int               `json:"employee_id"`
 	Customer     string            `json:"customer"`
 	Location     string            `json:"location"`
@@ -56,6 +63,7 @@ type OrderDetailSwag struct {
 }
 
 type OrderRequest struct {
+	Version      int           `json:"version"` // used when there is no If-Match header
 	EmployeeID   int           `json:"employee_id"`
 	Customer     string        `json:"customer"`
 	Location     string        `json:"location"`
@@ -77,29 +85,60 @@ func (OrderDetail) TableName() string {
 	return "order_detail"
 }
 
-// type OrderHistory struct {
-// 	gorm.Model
-// 	OrderID        int    `json:"order_id" gorm:"column:order_id"`
-// 	DetailLocation int    `json:"detail_location"`
-// 	Note           string `json:"note"`
-// 	Status         string `json:"status"` // "completed"
+// OrderHistory records every status change of an order, including its
+// creation (FromStatus is empty then).
+type OrderHistory struct {
+	ID               uint      `json:"id" gorm:"primarykey"`
+	CreatedAt        time.Time `json:"created_at"`
+	OrganizationID   uint      `json:"organization_id" gorm:"index"`
+	OrderID          int       `json:"order_id" gorm:"column:order_id;index"`
+	Action           string    `json:"action" gorm:"type:varchar(20)"`
+	FromStatus       string    `json:"from_status" gorm:"type:varchar(20)"`
+	ToStatus         string    `json:"to_status" gorm:"type:varchar(20)"`
+	ActorID          uint      `json:"actor_id"`
+	ActorName        string    `json:"actor_name"`
+	DetailLocationID *int      `json:"detail_location_id"`
+	ReasonCode       string    `json:"reason_code" gorm:"type:varchar(40)"`
+	Photo            string    `json:"photo"`
+	Note             string    `json:"note"`
+}
+
+func (OrderHistory) TableName() string {
+	return "order_history"
+}
 
-// 	Order    Order                `gorm:"foreignKey:OrderID; references:ID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
-// 	Location users.DetailLocation `gorm:"foreignKey:DetailLocation; references:ID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
-// }
+// newHistory starts the history entry of an action by the logged in user.
+func newHistory(loginData middlewares.UserLoginRedis, order Order, action string) OrderHistory {
+	return OrderHistory{
+		OrganizationID: loginData.OrganizationID,
+		OrderID:        int(order.ID),
+		Action:         action,
+		FromStatus:     order.Status,
+		ActorID:        uint(loginData.UserId),
+		ActorName:      loginData.Username,
+	}
+}
 
-// func (OrderHistory) TableName() string {
-// 	return "order_history"
-// }
+// HashFilename returns a unique name to store an upload under. The random
+// salt keeps uploads with the same client file name, e.g. IMG_0001.jpg, from
+// overwriting each other.
+func HashFilename(filename string) (string, error) {
+	salt, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
-func HashFilename(filename string) string {
 	hash := sha256.New()
+	hash.Write([]byte(salt))
 	hash.Write([]byte(filename))
-	return hex.EncodeToString(hash.Sum(nil)) + filepath.Ext(filename)
+	return hex.EncodeToString(hash.Sum(nil)) + filepath.Ext(filename), nil
 }
 
+// ipInfoURL is the lookup getIPInfo formats the address into.
+var ipInfoURL = "https://ipinfo.io/%s/json"
+
 func getIPInfo(ip string) (*users.IPInfo, error) {
-	resp, err := http.Get(fmt.Sprintf("https://ipinfo.io/%s/json", ip))
+	resp, err := http.Get(fmt.Sprintf(ipInfoURL, ip))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,203 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/middlewares"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OrderReason is a reason code an organization offers for ending up in
+// Status. Organizations without their own codes for a status get the
+// DefaultReasons.
+type OrderReason struct {
+	gorm.Model
+	OrganizationID uint   `json:"organization_id" gorm:"uniqueIndex:idx_order_reason"`
+	Status         string `json:"status" gorm:"type:varchar(20);uniqueIndex:idx_order_reason"`
+	Code           string `json:"code" gorm:"type:varchar(40);uniqueIndex:idx_order_reason"`
+	Label          string `json:"label"`
+}
+
+func (OrderReason) TableName() string {
+	return "order_reasons"
+}
+
+// DefaultReasons is the reason catalogue of every status that needs one.
+var DefaultReasons = map[string][]OrderReason{
+	StatusCancelled: {
+		{Code: "customer_request", Label: "Cancelled by the customer"},
+		{Code: "duplicate", Label: "Duplicate order"},
+		{Code: "out_of_stock", Label: "Items out of stock"},
+	},
+	StatusFailed: {
+		{Code: "customer_absent", Label: "Nobody at the address"},
+		{Code: "wrong_address", Label: "Address wrong or not found"},
+		{Code: "refused", Label: "Parcel refused"},
+		{Code: "damaged", Label: "Parcel damaged"},
+	},
+	StatusReturned: {
+		{Code: "refused", Label: "Parcel refused"},
+		{Code: "undeliverable", Label: "Could not be delivered"},
+		{Code: "damaged", Label: "Parcel damaged"},
+	},
+}
+
+// reasonStatus reports whether status takes reason codes.
+func reasonStatus(status string) bool {
+	_, ok := DefaultReasons[status]
+	return ok
+}
+
+var reasonCodePattern = regexp.MustCompile(`^[a-z0-9_]{1,40}$`)
+
+type ReasonRequest struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+}
+
+// ReasonsRequest replaces the reason codes of one status. An empty list goes
+// back to the defaults.
+type ReasonsRequest struct {
+	Reasons []ReasonRequest `json:"reasons"`
+}
+
+func (r *ReasonsRequest) ValidateReasons() (err error) {
+	seen := map[string]bool{}
+
+	for i := range r.Reasons {
+		reason := &r.Reasons[i]
+		reason.Code = strings.TrimSpace(reason.Code)
+
+		if !reasonCodePattern.MatchString(reason.Code) {
+			return fmt.Errorf("invalid reason code %q, use lowercase letters, digits and _", reason.Code)
+		}
+
+		if common.IsEmptyField(reason.Label) {
+			return fmt.Errorf("label of reason %q required", reason.Code)
+		}
+
+		if seen[reason.Code] {
+			return fmt.Errorf("duplicate reason code %q", reason.Code)
+		}
+		seen[reason.Code] = true
+	}
+
+	return
+}
+
+func (r *ReasonsRequest) ConvertToModel(orgID uint, status string) []OrderReason {
+	reasons := make([]OrderReason, 0, len(r.Reasons))
+	for _, reason := range r.Reasons {
+		reasons = append(reasons, OrderReason{
+			OrganizationID: orgID,
+			Status:         status,
+			Code:           reason.Code,
+			Label:          reason.Label,
+		})
+	}
+
+	return reasons
+}
+
+// TransitionRequest is the body of the order actions that take a reason.
+// Photo is an optional "file" of the multipart form.
+type TransitionRequest struct {
+	Reason string `json:"reason" form:"reason"`
+	Note   string `json:"note" form:"note"`
+}
+
+// RetryRequest reschedules a failed order, optionally to another employee.
+type RetryRequest struct {
+	EmployeeID  int       `json:"employee_id" form:"employee_id"`
+	ScheduledAt time.Time `json:"scheduled_at" form:"scheduled_at" time_format:"2006-01-02T15:04:05Z07:00"`
+	Note        string    `json:"note" form:"note"`
+}
+
+func hasReason(reasons []OrderReason, code string) bool {
+	for _, reason := range reasons {
+		if reason.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reasonsFor returns the reason codes the organization offers for status,
+// falling back to the defaults.
+func (o *orderServices) reasonsFor(orgID uint, status string) ([]OrderReason, error) {
+	catalogue, err := o.reasonCatalogue(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalogue[status], nil
+}
+
+// reasonCatalogue returns the effective reason codes of every status that
+// takes them.
+func (o *orderServices) reasonCatalogue(orgID uint) (map[string][]OrderReason, error) {
+	custom, err := o.repository.GetReasons(orgID)
+	if err != nil {
+		return nil, errors.New("unable to load reason codes")
+	}
+
+	catalogue := map[string][]OrderReason{}
+	for _, reason := range custom {
+		catalogue[reason.Status] = append(catalogue[reason.Status], reason)
+	}
+
+	for status, defaults := range DefaultReasons {
+		if len(catalogue[status]) > 0 {
+			continue
+		}
+
+		for _, reason := range defaults {
+			reason.OrganizationID = orgID
+			reason.Status = status
+			catalogue[status] = append(catalogue[status], reason)
+		}
+	}
+
+	return catalogue, nil
+}
+
+// GetReasons implements Service.
+func (o *orderServices) GetReasons(ctx *gin.Context) (result map[string][]OrderReason, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	return o.reasonCatalogue(loginData.OrganizationID)
+}
+
+// UpdateReasons implements Service.
+func (o *orderServices) UpdateReasons(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	status := ctx.Param("status")
+	if !reasonStatus(status) {
+		return fmt.Errorf("status %q takes no reason codes", status)
+	}
+
+	var request ReasonsRequest
+	if err = ctx.ShouldBindJSON(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.ValidateReasons(); err != nil {
+		return
+	}
+
+	return o.repository.ReplaceReasons(loginData.OrganizationID, status, request.ConvertToModel(loginData.OrganizationID, status))
+}
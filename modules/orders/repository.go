@@ -2,22 +2,31 @@ package orders
 
 import (
 	"errors"
+	"fmt"
 	"gotrack/modules/users"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
-	Create(order *Order) error
-	GetAll(role string, idUser int, search string, page int, limit int) (result []Order, err error)
-	GetByID(id int) (Order, error)
-	Delete(id int) error
-	Update(order Order, id int, details []OrderDetail) error
-	FindEmployee(id int) (*users.User, error)
-	IsOrderExists(id int) (bool, error)
+	Create(order *Order, history OrderHistory) error
+	GetAll(orgID uint, employeeID int, search string, page int, limit int) (result []Order, err error)
+	GetByID(orgID uint, id int) (Order, error)
+	Delete(orgID uint, id int) error
+	Update(orgID uint, order Order, id int, version int, details []OrderDetail) error
+	Patch(orgID uint, id int, version int, changes map[string]interface{}) error
+	CreateDetail(orgID uint, id int, version int, detail *OrderDetail) error
+	UpdateDetail(orgID uint, id int, version int, detailID int, changes map[string]interface{}) error
+	DeleteDetail(orgID uint, id int, version int, detailID int) error
+	FindEmployee(orgID uint, id int) (*users.User, error)
+	IsOrderExists(orgID uint, id int) (bool, error)
 	CreateOrderDetails(details []OrderDetail) error
-	Delivery(id int) error
-	Success(id int, ip string, filename string) error
+	Transition(orgID uint, id int, transition Transition, history OrderHistory, changes map[string]interface{}) error
+	Success(orgID uint, id int, ip string, filename string, history OrderHistory) error
+	GetHistory(orgID uint, id int) ([]OrderHistory, error)
+	GetReasons(orgID uint) ([]OrderReason, error)
+	ReplaceReasons(orgID uint, status string, reasons []OrderReason) error
 }
 
 type orderRepository struct {
@@ -42,40 +51,51 @@ func (o *orderRepository) DeleteOrderDetails(id int) error {
 	})
 }
 
-func (o *orderRepository) FindEmployee(id int) (*users.User, error) {
+func (o *orderRepository) FindEmployee(orgID uint, id int) (*users.User, error) {
 	var user users.User
 
-	if err := o.db.First(&user, id).Error; err != nil {
+	if err := o.db.Where("organization_id = ?", orgID).First(&user, id).Error; err != nil {
 		return nil, err
 	}
 
 	return &user, nil
 }
 
-func (o *orderRepository) IsOrderExists(id int) (bool, error) {
+func (o *orderRepository) IsOrderExists(orgID uint, id int) (bool, error) {
 	var order Order
 
-	if err := o.db.First(&order, id).Error; err != nil {
+	if err := o.db.Where("organization_id = ?", orgID).First(&order, id).Error; err != nil {
 		return false, err
 	}
 
 	return true, nil
 }
 
-// Create implements Repository.
-func (o *orderRepository) Create(order *Order) error {
-	if err := o.db.Create(order).Error; err != nil {
-		return err
-	}
-	return nil
+// Create implements Repository. history is completed with the new order id.
+func (o *orderRepository) Create(order *Order, history OrderHistory) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		history.OrderID = int(order.ID)
+		history.ToStatus = order.Status
+
+		return tx.Create(&history).Error
+	})
 }
 
 // Delete implements Repository.
-func (o *orderRepository) Delete(id int) error {
+func (o *orderRepository) Delete(orgID uint, id int) error {
 	var order Order
 
-	if err := o.db.Delete(&order, id).Error; err != nil {
-		return err
+	result := o.db.Where("organization_id = ?", orgID).Delete(&order, id)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil
 	}
 
 	o.DeleteOrderDetails(id)
@@ -84,12 +104,12 @@ func (o *orderRepository) Delete(id int) error {
 }
 
 // GetAll implements Repository.
-func (o *orderRepository) GetAll(role string, idUser int, search string, page int, limit int) (result []Order, err error) {
+func (o *orderRepository) GetAll(orgID uint, employeeID int, search string, page int, limit int) (result []Order, err error) {
 	var data []Order
-	query := o.db.Model(&Order{}).Preload("OrderDetails").Preload("Employee")
+	query := o.db.Model(&Order{}).Preload("OrderDetails").Preload("Employee").Where("organization_id = ?", orgID)
 
 	if search != "" {
-		query = query.Where("customer LIKE ? OR location LIKE ? OR status LIKE ? OR description LIKE ?",
+		query = query.Where("(customer LIKE ? OR location LIKE ? OR status LIKE ? OR description LIKE ?)",
 			"%"+search+"%", "%"+search+"%", "%"+search+"%", "%"+search+"%")
 	}
 
@@ -99,24 +119,23 @@ func (o *orderRepository) GetAll(role string, idUser int, search string, page in
 		query = query.Limit(limit).Offset(offset)
 	}
 
-	if role == "owner" {
-		if err = query.Find(&data).Error; err != nil {
-			return nil, err
-		}
-	} else if role == "employee" {
-		if err = query.Where("employee_id = ?", idUser).Find(&data).Error; err != nil {
-			return nil, err
-		}
+	// employeeID 0 lists every order of the organization
+	if employeeID != 0 {
+		query = query.Where("employee_id = ?", employeeID)
+	}
+
+	if err = query.Find(&data).Error; err != nil {
+		return nil, err
 	}
 
 	return data, nil
 }
 
 // GetByID implements Repository.
-func (o *orderRepository) GetByID(id int) (Order, error) {
+func (o *orderRepository) GetByID(orgID uint, id int) (Order, error) {
 	var order Order
 
-	err := o.db.Model(&Order{}).Where("id = ?", id).
+	err := o.db.Model(&Order{}).Where("id = ? AND organization_id = ?", id, orgID).
 		Preload("OrderDetails").
 		Preload("Employee").
 		First(&order).Error
@@ -125,8 +144,8 @@ func (o *orderRepository) GetByID(id int) (Order, error) {
 	}
 
 	// Jika status adalah "Success", preload DetailLocation dan Location
-	if order.Status == "Success" {
-		err = o.db.Preload("DetailLocation.Location").Where("id = ?", id).First(&order).Error
+	if order.Status == StatusSuccess {
+		err = o.db.Preload("DetailLocation.Location").Where("id = ? AND organization_id = ?", id, orgID).First(&order).Error
 		if err != nil {
 			return Order{}, err
 		}
@@ -137,56 +156,200 @@ func (o *orderRepository) GetByID(id int) (Order, error) {
 	return order, nil
 }
 
-// Update implements Repository.
-func (o *orderRepository) Update(order Order, id int, details []OrderDetail) error {
+// bumpVersion moves the order from version to the next one, or fails with
+// ErrVersionMismatch when it is not at version anymore. Every edit starts
+// with it, so the order row stays locked until the edit commits.
+func bumpVersion(tx *gorm.DB, orgID uint, id int, version int) error {
+	result := tx.Model(&Order{}).
+		Where("id = ? AND organization_id = ? AND version = ?", id, orgID, version).
+		UpdateColumn("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// Update implements Repository. It only applies while the order is still at
+// version, and bumps it. Details keep their ids: those with an ID are
+// updated, new ones created and missing ones deleted.
+func (o *orderRepository) Update(orgID uint, order Order, id int, version int, details []OrderDetail) error {
 	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, orgID, id, version); err != nil {
+			return err
+		}
+
 		// Update order
-		if err := tx.Model(&Order{}).Where("id = ?", id).Updates(order).Error; err != nil {
+		if err := tx.Model(&Order{}).Where("id = ? AND organization_id = ?", id, orgID).Updates(order).Error; err != nil {
 			return err
 		}
 
-		// Delete existing order details
-		if err := tx.Where("order_id = ?", id).Delete(&OrderDetail{}).Error; err != nil {
+		var (
+			keep    []uint
+			created []OrderDetail
+		)
+		for _, detail := range details {
+			if detail.ID == 0 {
+				created = append(created, detail)
+				continue
+			}
+
+			if err := updateDetail(tx, id, int(detail.ID), map[string]interface{}{"item": detail.Item, "qty": detail.Qty}); err != nil {
+				return err
+			}
+			keep = append(keep, detail.ID)
+		}
+
+		// Delete the order details that were left out
+		remove := tx.Where("order_id = ?", id)
+		if len(keep) > 0 {
+			remove = remove.Where("id NOT IN ?", keep)
+		}
+		if err := remove.Delete(&OrderDetail{}).Error; err != nil {
 			return err
 		}
 
-		if err := tx.Create(&details).Error; err != nil {
+		if len(created) == 0 {
+			return nil
+		}
+
+		return tx.Create(&created).Error
+	})
+}
+
+// Patch implements Repository.
+func (o *orderRepository) Patch(orgID uint, id int, version int, changes map[string]interface{}) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, orgID, id, version); err != nil {
 			return err
 		}
 
-		return nil
+		return tx.Model(&Order{}).Where("id = ? AND organization_id = ?", id, orgID).Updates(changes).Error
 	})
 }
 
-// Delivery implements Repository.
-func (o *orderRepository) Delivery(id int) error {
-	var order Order
+// CreateDetail implements Repository.
+func (o *orderRepository) CreateDetail(orgID uint, id int, version int, detail *OrderDetail) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, orgID, id, version); err != nil {
+			return err
+		}
 
-	if err := o.db.Select("Status").First(&order, id).Error; err != nil {
-		return errors.New("data order tidak ditemukan")
-	}
+		detail.OrderID = id
 
-	if order.Status != "Pending" {
-		return errors.New("status harus pending")
+		return tx.Create(detail).Error
+	})
+}
+
+func updateDetail(tx *gorm.DB, id int, detailID int, changes map[string]interface{}) error {
+	result := tx.Model(&OrderDetail{}).Where("id = ? AND order_id = ?", detailID, id).Updates(changes)
+	if result.Error != nil {
+		return result.Error
 	}
 
-	if err := o.db.Model(&Order{}).Where("id = ?", id).Update("Status", "Delivery").Error; err != nil {
-		return err
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("order detail with ID %d does not exist", detailID)
 	}
 
 	return nil
 }
 
-// Success implements Repository.
-func (o *orderRepository) Success(id int, ip string, filename string) error {
-	var order Order
+// UpdateDetail implements Repository.
+func (o *orderRepository) UpdateDetail(orgID uint, id int, version int, detailID int, changes map[string]interface{}) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, orgID, id, version); err != nil {
+			return err
+		}
 
-	if err := o.db.Select("Status").First(&order, id).Error; err != nil {
-		return errors.New("data order tidak ditemukan")
-	}
+		return updateDetail(tx, id, detailID, changes)
+	})
+}
+
+// DeleteDetail implements Repository.
+func (o *orderRepository) DeleteDetail(orgID uint, id int, version int, detailID int) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, orgID, id, version); err != nil {
+			return err
+		}
+
+		result := tx.Where("id = ? AND order_id = ?", detailID, id).Delete(&OrderDetail{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("order detail with ID %d does not exist", detailID)
+		}
+
+		return nil
+	})
+}
+
+// transition changes the status, together with changes to other columns, and
+// records it in one transaction. The order row stays locked until the commit
+// and the update only applies to the status that was checked, so of two
+// concurrent requests the loser gets a ConflictError. effects runs in the
+// same transaction after the status changed and may add to the history, e.g.
+// the proof of delivery.
+func (o *orderRepository) transition(orgID uint, id int, transition Transition, history OrderHistory, changes map[string]interface{}, effects func(tx *gorm.DB, history *OrderHistory) error) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		var order Order
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("status").Where("organization_id = ?", orgID).First(&order, id).Error
+		if err != nil {
+			return errors.New("data order tidak ditemukan")
+		}
+
+		if !transition.Allows(order.Status) {
+			return &ConflictError{Action: transition.Action, Status: order.Status}
+		}
+
+		updates := map[string]interface{}{"status": transition.To, "version": gorm.Expr("version + 1")}
+		for column, value := range changes {
+			updates[column] = value
+		}
+
+		result := tx.Model(&Order{}).
+			Where("id = ? AND organization_id = ? AND status = ?", id, orgID, order.Status).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
 
-	if order.Status != "Delivery" {
-		return errors.New("status harus delivery")
+		if result.RowsAffected == 0 {
+			return &ConflictError{Action: transition.Action, Status: order.Status}
+		}
+
+		if effects != nil {
+			if err := effects(tx, &history); err != nil {
+				return err
+			}
+		}
+
+		history.OrderID = id
+		history.FromStatus = order.Status
+		history.ToStatus = transition.To
+
+		return tx.Create(&history).Error
+	})
+}
+
+// Transition implements Repository.
+func (o *orderRepository) Transition(orgID uint, id int, transition Transition, history OrderHistory, changes map[string]interface{}) error {
+	return o.transition(orgID, id, transition, history, changes, nil)
+}
+
+// Success implements Repository. It completes the order with the proof of
+// delivery and where it was uploaded from.
+func (o *orderRepository) Success(orgID uint, id int, ip string, filename string, history OrderHistory) error {
+	transition, err := FindTransition(ActionComplete)
+	if err != nil {
+		return err
 	}
 
 	ipInfo, err := getIPInfo(ip)
@@ -194,36 +357,66 @@ func (o *orderRepository) Success(id int, ip string, filename string) error {
 		return errors.New("unable to get IP info")
 	}
 
-	return o.db.Transaction(func(tx *gorm.DB) error {
+	return o.transition(orgID, id, transition, history, nil, func(tx *gorm.DB, history *OrderHistory) error {
 		ipRecord := &users.IPInfo{
-			IP:       ipInfo.IP,
-			Hostname: ipInfo.Hostname,
-			City:     ipInfo.City,
-			Region:   ipInfo.Region,
-			Country:  ipInfo.Country,
-			Loc:      ipInfo.Loc,
-			Org:      ipInfo.Org,
-			Postal:   ipInfo.Postal,
-			Timezone: ipInfo.Timezone,
+			OrganizationID: orgID,
+			IP:             ipInfo.IP,
+			Hostname:       ipInfo.Hostname,
+			City:           ipInfo.City,
+			Region:         ipInfo.Region,
+			Country:        ipInfo.Country,
+			Loc:            ipInfo.Loc,
+			Org:            ipInfo.Org,
+			Postal:         ipInfo.Postal,
+			Timezone:       ipInfo.Timezone,
 		}
 		if err := tx.Create(ipRecord).Error; err != nil {
 			return errors.New("unable to save IP info")
 		}
 
 		detailLocation := &users.DetailLocation{
-			IpID:    int(ipRecord.ID),
-			OrderID: id,
-			Pict:    filename,
+			OrganizationID: orgID,
+			IpID:           int(ipRecord.ID),
+			OrderID:        id,
+			Pict:           filename,
 		}
 		if err := tx.Create(detailLocation).Error; err != nil {
 			return errors.New("unable to save detail location")
 		}
 
-		if err := tx.Model(&Order{}).Where("id = ?", id).Update("Status", "Success").Error; err != nil {
+		detailLocationID := int(detailLocation.ID)
+		history.DetailLocationID = &detailLocationID
+
+		return nil
+	})
+}
+
+// GetHistory implements Repository.
+func (o *orderRepository) GetHistory(orgID uint, id int) (history []OrderHistory, err error) {
+	err = o.db.Where("organization_id = ? AND order_id = ?", orgID, id).Order("created_at, id").Find(&history).Error
+	return
+}
+
+// GetReasons implements Repository. It only returns the codes the
+// organization set itself.
+func (o *orderRepository) GetReasons(orgID uint) (reasons []OrderReason, err error) {
+	err = o.db.Where("organization_id = ?", orgID).Order("status, id").Find(&reasons).Error
+	return
+}
+
+// ReplaceReasons implements Repository.
+func (o *orderRepository) ReplaceReasons(orgID uint, status string, reasons []OrderReason) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		// codes are unique per status, so old rows are removed for good
+		if err := tx.Unscoped().Where("organization_id = ? AND status = ?", orgID, status).Delete(&OrderReason{}).Error; err != nil {
 			return err
 		}
 
-		return nil
+		if len(reasons) == 0 {
+			return nil
+		}
+
+		return tx.Create(&reasons).Error
 	})
 }
 
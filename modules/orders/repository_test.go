@@ -0,0 +1,275 @@
+package orders
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"gotrack/modules/users"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to the Postgres in GOTRACK_TEST_DATABASE_URL, e.g.
+// "host=localhost user=postgres password=admin dbname=gotrack_test
+// sslmode=disable". Tests that need it are skipped without one.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GOTRACK_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("GOTRACK_TEST_DATABASE_URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	if err = db.AutoMigrate(&users.User{}, &users.IPInfo{}, &users.DetailLocation{}, &Order{}, &OrderDetail{}, &OrderHistory{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return db
+}
+
+// testOrder creates a pending order in an organization of its own.
+func testOrder(t *testing.T, db *gorm.DB) Order {
+	t.Helper()
+
+	orgID := uint(time.Now().UnixNano() % 1_000_000_000)
+
+	employee := users.User{
+		OrganizationID: orgID,
+		Username:       fmt.Sprintf("courier-%d", time.Now().UnixNano()),
+		Role:           "employee",
+	}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatalf("create employee: %v", err)
+	}
+
+	order := Order{
+		OrganizationID: orgID,
+		EmployeeID:     int(employee.ID),
+		Customer:       "customer",
+		Location:       "location",
+		Status:         StatusPending,
+	}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Unscoped().Where("order_id = ?", order.ID).Delete(&OrderHistory{})
+		db.Unscoped().Where("order_id = ?", order.ID).Delete(&users.DetailLocation{})
+		db.Unscoped().Where("organization_id = ?", orgID).Delete(&users.IPInfo{})
+		db.Unscoped().Delete(&order)
+		db.Unscoped().Delete(&employee)
+	})
+
+	return order
+}
+
+func TestConcurrentTransitions(t *testing.T) {
+	db := testDB(t)
+	repository := NewRepository(db)
+
+	const requests = 10
+
+	for _, action := range []string{ActionDeliver, ActionCancel} {
+		t.Run(action, func(t *testing.T) {
+			order := testOrder(t, db)
+
+			transition, err := FindTransition(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				wg    sync.WaitGroup
+				start = make(chan struct{})
+				errs  = make([]error, requests)
+			)
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+
+					history := OrderHistory{
+						OrganizationID: order.OrganizationID,
+						Action:         action,
+						ActorID:        uint(order.EmployeeID),
+					}
+					errs[i] = repository.Transition(order.OrganizationID, int(order.ID), transition, history, nil)
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			succeeded := 0
+			for _, err := range errs {
+				var conflict *ConflictError
+				switch {
+				case err == nil:
+					succeeded++
+				case errors.As(err, &conflict):
+					if conflict.Status != transition.To {
+						t.Errorf("conflict status = %q, want %q", conflict.Status, transition.To)
+					}
+				default:
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+
+			if succeeded != 1 {
+				t.Errorf("%d of %d transitions succeeded, want exactly 1", succeeded, requests)
+			}
+
+			var histories int64
+			if err = db.Model(&OrderHistory{}).Where("order_id = ? AND action = ?", order.ID, action).Count(&histories).Error; err != nil {
+				t.Fatal(err)
+			}
+			if histories != 1 {
+				t.Errorf("%d history rows written, want 1", histories)
+			}
+
+			var stored Order
+			if err = db.First(&stored, order.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != transition.To || stored.Version != order.Version+1 {
+				t.Errorf("order is %s at version %d, want %s at version %d", stored.Status, stored.Version, transition.To, order.Version+1)
+			}
+		})
+	}
+}
+
+// proofRequest is a POST of a proof photo to the success action of order.
+func proofRequest(t *testing.T, order Order) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	file, err := form.CreateFormFile("file", "proof.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("photo"))
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/orders/%d/success", order.ID), &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	return request
+}
+
+// TestConcurrentSuccess double taps the success action of a courier: one
+// request completes the order, the other conflicts and leaves no photo
+// behind.
+func TestConcurrentSuccess(t *testing.T) {
+	db := testDB(t)
+	service := NewService(NewRepository(db))
+	gin.SetMode(gin.TestMode)
+
+	ipInfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip":"192.0.2.1","country":"ID"}`))
+	}))
+	defer ipInfo.Close()
+
+	defaultURL, defaultDir := ipInfoURL, publicDir
+	ipInfoURL, publicDir = ipInfo.URL+"/%s/json", t.TempDir()
+	t.Cleanup(func() { ipInfoURL, publicDir = defaultURL, defaultDir })
+
+	order := testOrder(t, db)
+	if err := db.Model(&order).Update("status", StatusDelivery).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rbac.Load([]rbac.Role{{
+		OrganizationID: order.OrganizationID,
+		Name:           "employee",
+		Permissions:    []rbac.RolePermission{{Permission: rbac.OrderComplete}},
+	}})
+
+	const requests = 2
+
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		errs  = make([]error, requests)
+	)
+	for i := 0; i < requests; i++ {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = proofRequest(t, order)
+		ctx.Params = gin.Params{{Key: "id", Value: fmt.Sprint(order.ID)}}
+		ctx.Set("auth", middlewares.UserLoginRedis{
+			UserId:         int64(order.EmployeeID),
+			OrganizationID: order.OrganizationID,
+			Role:           "employee",
+		})
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			errs[i] = service.Success(ctx)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		var conflict *ConflictError
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.As(err, &conflict):
+			if conflict.Status != StatusSuccess {
+				t.Errorf("conflict status = %q, want %q", conflict.Status, StatusSuccess)
+			}
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Errorf("%d of %d completions succeeded, want exactly 1", succeeded, requests)
+	}
+
+	var histories int64
+	if err := db.Model(&OrderHistory{}).Where("order_id = ? AND action = ?", order.ID, ActionComplete).Count(&histories).Error; err != nil {
+		t.Fatal(err)
+	}
+	if histories != 1 {
+		t.Errorf("%d history rows written, want 1", histories)
+	}
+
+	photos, err := os.ReadDir(publicDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 1 {
+		t.Errorf("%d photos kept, want only the proof of the completion", len(photos))
+	}
+}
@@ -1,6 +1,7 @@
 package orders
 
 import (
+	"gotrack/helpers/rbac"
 	"gotrack/middlewares"
 
 	"github.com/gin-gonic/gin"
@@ -8,16 +9,27 @@ import (
 
 func Initiator(router *gin.Engine) {
 	api := router.Group("/api/order")
-	api.Use(middlewares.JwtMiddleware())
+	api.Use(middlewares.JwtOrAPIKeyMiddleware())
 	api.Use(middlewares.Logging())
 	{
-		api.POST("", Create)
-		api.GET("", GetAll)
-		api.GET(":id", GetByID)
-		api.PUT(":id", middlewares.AuthorizeRole("owner"), Update)
-		api.DELETE(":id", middlewares.AuthorizeRole("owner"), Delete)
+		api.POST("", middlewares.RequirePermission(rbac.OrderCreate), Create)
+		api.GET("", middlewares.RequirePermission(rbac.OrderReadAll, rbac.OrderReadOwn), GetAll)
+		api.GET(":id", middlewares.RequirePermission(rbac.OrderReadAll, rbac.OrderReadOwn), GetByID)
+		api.PUT(":id", middlewares.RequirePermission(rbac.OrderUpdate), Update)
+		api.PATCH(":id", middlewares.RequirePermission(rbac.OrderUpdate), Patch)
+		api.DELETE(":id", middlewares.RequirePermission(rbac.OrderDelete), Delete)
+		api.GET(":id/history", middlewares.RequirePermission(rbac.OrderReadAll, rbac.OrderReadOwn), History)
+		api.POST(":id/details", middlewares.RequirePermission(rbac.OrderUpdate), CreateDetail)
+		api.PATCH(":id/details/:detailId", middlewares.RequirePermission(rbac.OrderUpdate), UpdateDetail)
+		api.DELETE(":id/details/:detailId", middlewares.RequirePermission(rbac.OrderUpdate), DeleteDetail)
+		api.GET("/reasons", middlewares.RequirePermission(rbac.OrderReadAll, rbac.OrderReadOwn), GetReasons)
+		api.PUT("/reasons/:status", middlewares.RequirePermission(rbac.OrganizationManage), UpdateReasons)
 
-		api.POST("/delivery/:id", middlewares.AuthorizeRole("employee"), Delivery)
-		api.POST("/success/:id", middlewares.AuthorizeRole("employee"), Success)
+		api.POST("/delivery/:id", middlewares.RequirePermission(rbac.OrderDeliver), Delivery)
+		api.POST("/success/:id", middlewares.RequirePermission(rbac.OrderComplete), Success)
+		api.POST("/cancel/:id", middlewares.RequirePermission(rbac.OrderUpdate), Cancel)
+		api.POST("/fail/:id", middlewares.RequirePermission(rbac.OrderDeliver), Fail)
+		api.POST("/return/:id", middlewares.RequirePermission(rbac.OrderDeliver), Return)
+		api.POST("/retry/:id", middlewares.RequirePermission(rbac.OrderUpdate), Retry)
 	}
 }
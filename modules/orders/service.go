@@ -4,12 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
 	"gotrack/middlewares"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -20,10 +24,23 @@ type Service interface {
 	GetAll(ctx *gin.Context) (result []Order, err error)
 	GetById(ctx *gin.Context) (result Order, err error)
 	Update(ctx *gin.Context) (err error)
+	Patch(ctx *gin.Context) (err error)
 	Delete(ctx *gin.Context) (err error)
 
+	CreateDetail(ctx *gin.Context) (result OrderDetail, err error)
+	UpdateDetail(ctx *gin.Context) (err error)
+	DeleteDetail(ctx *gin.Context) (err error)
+
 	Delivery(ctx *gin.Context) (err error)
 	Success(ctx *gin.Context) (err error)
+	Cancel(ctx *gin.Context) (err error)
+	Fail(ctx *gin.Context) (err error)
+	Return(ctx *gin.Context) (err error)
+	Retry(ctx *gin.Context) (err error)
+	History(ctx *gin.Context) (result []OrderHistory, err error)
+
+	GetReasons(ctx *gin.Context) (result map[string][]OrderReason, err error)
+	UpdateReasons(ctx *gin.Context) (err error)
 }
 
 type orderServices struct {
@@ -33,6 +50,11 @@ type orderServices struct {
 
 // Create implements Service.
 func (o *orderServices) Create(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
 	var request = OrderRequest{}
 
 	if err = ctx.BindJSON(&request); err != nil {
@@ -44,24 +66,25 @@ func (o *orderServices) Create(ctx *gin.Context) (err error) {
 		return errors.New("validation failed: " + err.Error())
 	}
 
-	dataUser, err := o.repository.FindEmployee(request.EmployeeID)
+	dataUser, err := o.repository.FindEmployee(loginData.OrganizationID, request.EmployeeID)
 	if err != nil {
 		return errors.New("employee not found")
 	}
 
-	if dataUser.Role == "owner" {
-		return errors.New("this user is owner")
+	if !rbac.Can(loginData.OrganizationID, dataUser.Role, rbac.OrderDeliver) {
+		return errors.New("this user can not deliver orders")
 	}
 
 	order := Order{
-		EmployeeID:  request.EmployeeID,
-		Customer:    request.Customer,
-		Location:    request.Location,
-		Description: request.Description,
-		Status:      "Pending",
+		OrganizationID: loginData.OrganizationID,
+		EmployeeID:     request.EmployeeID,
+		Customer:       request.Customer,
+		Location:       request.Location,
+		Description:    request.Description,
+		Status:         StatusPending,
 	}
 
-	if err = o.repository.Create(&order); err != nil {
+	if err = o.repository.Create(&order, newHistory(loginData, Order{}, ActionCreate)); err != nil {
 		return err
 	}
 
@@ -76,7 +99,7 @@ func (o *orderServices) Create(ctx *gin.Context) (err error) {
 	}
 
 	if err = o.repository.CreateOrderDetails(request.OrderDetails); err != nil {
-		o.repository.Delete(int(order.ID))
+		o.repository.Delete(loginData.OrganizationID, int(order.ID))
 		return err
 	}
 
@@ -85,12 +108,17 @@ func (o *orderServices) Create(ctx *gin.Context) (err error) {
 
 // Delete implements Service.
 func (o *orderServices) Delete(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		return fmt.Errorf("invalid ID format")
 	}
 
-	exists, err := o.repository.IsOrderExists(id)
+	exists, err := o.repository.IsOrderExists(loginData.OrganizationID, id)
 	if err != nil {
 		return err
 	}
@@ -105,7 +133,7 @@ func (o *orderServices) Delete(ctx *gin.Context) (err error) {
 		return
 	}
 
-	if err = o.repository.Delete(id); err != nil {
+	if err = o.repository.Delete(loginData.OrganizationID, id); err != nil {
 		return err
 	}
 
@@ -132,17 +160,29 @@ func (o *orderServices) GetAll(ctx *gin.Context) (result []Order, err error) {
 		return
 	}
 
-	return o.repository.GetAll(loginData.Role, int(loginData.UserId), search, page, limit)
+	// without order:read:all only the orders assigned to the caller are listed
+	employeeID := 0
+	if !loginData.Can(rbac.OrderReadAll) {
+		employeeID = int(loginData.UserId)
+	}
+
+	return o.repository.GetAll(loginData.OrganizationID, employeeID, search, page, limit)
 }
 
-// GetById implements Service.
+// GetById implements Service. Without order:read:all only orders assigned to
+// the caller can be read.
 func (o *orderServices) GetById(ctx *gin.Context) (result Order, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return Order{}, err
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		return Order{}, fmt.Errorf("invalid ID format")
 	}
 
-	exists, err := o.repository.IsOrderExists(id)
+	exists, err := o.repository.IsOrderExists(loginData.OrganizationID, id)
 	if err != nil {
 		return Order{}, err
 	}
@@ -150,28 +190,62 @@ func (o *orderServices) GetById(ctx *gin.Context) (result Order, err error) {
 		return Order{}, errors.New("orders with ID does not exist")
 	}
 
-	data, err := o.repository.GetByID(id)
+	data, err := o.repository.GetByID(loginData.OrganizationID, id)
 	if err != nil {
 		return Order{}, err
 	}
 
+	if !loginData.Can(rbac.OrderReadAll) && data.EmployeeID != int(loginData.UserId) {
+		return Order{}, errors.New("orders with ID does not exist")
+	}
+
 	return data, nil
 }
 
-// Update implements Service.
-func (o *orderServices) Update(ctx *gin.Context) error {
+// loadOrder loads the :id order of the caller's organization.
+func (o *orderServices) loadOrder(ctx *gin.Context) (order Order, loginData middlewares.UserLoginRedis, err error) {
+	loginData, err = middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
+		err = fmt.Errorf("invalid ID format")
+		return
 	}
 
-	exists, err := o.repository.IsOrderExists(id)
+	order, err = o.repository.GetByID(loginData.OrganizationID, id)
 	if err != nil {
-		return err
+		err = errors.New("orders with ID does not exist")
 	}
-	if !exists {
-		return errors.New("orders with ID does not exist")
+
+	return
+}
+
+// checkVersion returns the version an edit of order is based on, see
+// expectedVersion, and refuses stale ones.
+func checkVersion(ctx *gin.Context, order Order, bodyVersion int) (int, error) {
+	version, err := expectedVersion(ctx.GetHeader("If-Match"), bodyVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	if version != order.Version {
+		return 0, ErrVersionMismatch
+	}
+
+	return version, nil
+}
+
+// Update implements Service. Details with an ID are kept and updated, the
+// others are added, and details left out are removed.
+func (o *orderServices) Update(ctx *gin.Context) error {
+	existing, loginData, err := o.loadOrder(ctx)
+	if err != nil {
+		return err
 	}
+	id := int(existing.ID)
 
 	var request OrderRequest
 	if err = ctx.BindJSON(&request); err != nil {
@@ -183,7 +257,16 @@ func (o *orderServices) Update(ctx *gin.Context) error {
 		return errors.New("validation failed: " + err.Error())
 	}
 
-	_, err = o.repository.FindEmployee(request.EmployeeID)
+	version, err := checkVersion(ctx, existing, request.Version)
+	if err != nil {
+		return err
+	}
+
+	if request.Status != "" && request.Status != existing.Status {
+		return errors.New("status can only be changed through the order actions")
+	}
+
+	_, err = o.repository.FindEmployee(loginData.OrganizationID, request.EmployeeID)
 	if err != nil {
 		return errors.New("employee not found")
 	}
@@ -192,7 +275,6 @@ func (o *orderServices) Update(ctx *gin.Context) error {
 		EmployeeID:  request.EmployeeID,
 		Customer:    request.Customer,
 		Location:    request.Location,
-		Status:      request.Status,
 		Description: request.Description,
 	}
 
@@ -209,48 +291,62 @@ func (o *orderServices) Update(ctx *gin.Context) error {
 		details = append(details, detail)
 	}
 
-	if err = o.repository.Update(order, id, details); err != nil {
+	if err = o.repository.Update(loginData.OrganizationID, order, id, version, details); err != nil {
 		return err
 	}
 
+	ctx.Header("ETag", ETag(version+1))
+
 	return nil
 }
 
-func (o *orderServices) Delivery(ctx *gin.Context) error {
-	id, err := strconv.Atoi(ctx.Param("id"))
+// startTransition loads the :id order and checks that the caller may apply
+// action to it.
+func (o *orderServices) startTransition(ctx *gin.Context, action string) (order Order, transition Transition, loginData middlewares.UserLoginRedis, err error) {
+	loginData, err = middlewares.GetLoginData(ctx)
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
+		return
 	}
 
-	exists, err := o.repository.IsOrderExists(id)
+	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		return err
+		err = fmt.Errorf("invalid ID format")
+		return
 	}
-	if !exists {
-		return errors.New("orders with ID does not exist")
+
+	order, err = o.repository.GetByID(loginData.OrganizationID, id)
+	if err != nil {
+		err = errors.New("orders with ID does not exist")
+		return
 	}
 
-	if err := o.repository.Delivery(id); err != nil {
-		return err
+	transition, err = FindTransition(action)
+	if err != nil {
+		return
 	}
 
-	return nil
+	err = transition.Check(order, loginData)
+	return
 }
 
-// Success implements Service.
-func (o *orderServices) Success(ctx *gin.Context) (err error) {
-	id, err := strconv.Atoi(ctx.Param("id"))
+// Delivery implements Service.
+func (o *orderServices) Delivery(ctx *gin.Context) error {
+	order, transition, loginData, err := o.startTransition(ctx, ActionDeliver)
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
+		return err
 	}
 
-	exists, err := o.repository.IsOrderExists(id)
+	return o.repository.Transition(loginData.OrganizationID, int(order.ID), transition, newHistory(loginData, order, ActionDeliver), nil)
+}
+
+// Success implements Service. The transition is checked before the proof is
+// written, and the proof is removed again if the transition fails, e.g.
+// because a concurrent request completed the order first.
+func (o *orderServices) Success(ctx *gin.Context) (err error) {
+	order, _, loginData, err := o.startTransition(ctx, ActionComplete)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.New("orders with ID does not exist")
-	}
 
 	// Parse the form data
 	if err = ctx.Request.ParseMultipartForm(5 << 20); err != nil {
@@ -258,38 +354,200 @@ func (o *orderServices) Success(ctx *gin.Context) (err error) {
 	}
 
 	// Get the file from the form input
-	file, header, err := ctx.Request.FormFile("file")
+	_, header, err := ctx.Request.FormFile("file")
 	if err != nil {
 		return errors.New("unable to get file from form")
 	}
+
+	fileName, err := saveUpload(header)
+	if err != nil {
+		return err
+	}
+
+	// Get IP address of the requester
+	ip := ctx.ClientIP()
+
+	if err = o.repository.Success(loginData.OrganizationID, int(order.ID), ip, fileName, newHistory(loginData, order, ActionComplete)); err != nil {
+		removeUpload(fileName)
+		return err
+	}
+
+	return
+}
+
+// Cancel implements Service.
+func (o *orderServices) Cancel(ctx *gin.Context) error {
+	return o.reasonTransition(ctx, ActionCancel)
+}
+
+// Fail implements Service.
+func (o *orderServices) Fail(ctx *gin.Context) error {
+	return o.reasonTransition(ctx, ActionFail)
+}
+
+// Return implements Service.
+func (o *orderServices) Return(ctx *gin.Context) error {
+	return o.reasonTransition(ctx, ActionReturn)
+}
+
+// reasonTransition applies an action that needs a reason code from the
+// catalogue of its target status, with an optional note and photo.
+func (o *orderServices) reasonTransition(ctx *gin.Context, action string) error {
+	order, transition, loginData, err := o.startTransition(ctx, action)
+	if err != nil {
+		return err
+	}
+
+	var request TransitionRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	history := newHistory(loginData, order, action)
+	history.Note = request.Note
+
+	if transition.RequiresReason {
+		if common.IsEmptyField(request.Reason) {
+			return errors.New("reason required")
+		}
+
+		reasons, err := o.reasonsFor(loginData.OrganizationID, transition.To)
+		if err != nil {
+			return err
+		}
+
+		if !hasReason(reasons, request.Reason) {
+			return fmt.Errorf("unknown reason %q for status %s", request.Reason, transition.To)
+		}
+
+		history.ReasonCode = request.Reason
+	}
+
+	// the photo is optional, e.g. of the closed door or the damaged parcel
+	if ctx.ContentType() == "multipart/form-data" {
+		header, err := ctx.FormFile("file")
+		if err != nil && !errors.Is(err, http.ErrMissingFile) {
+			return errors.New("unable to get file from form")
+		}
+
+		if header != nil {
+			if history.Photo, err = saveUpload(header); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = o.repository.Transition(loginData.OrganizationID, int(order.ID), transition, history, nil)
+	if err != nil && history.Photo != "" {
+		removeUpload(history.Photo)
+	}
+
+	return err
+}
+
+// Retry implements Service. It puts a failed order back to Pending,
+// optionally for another employee and a later time.
+func (o *orderServices) Retry(ctx *gin.Context) error {
+	order, transition, loginData, err := o.startTransition(ctx, ActionRetry)
+	if err != nil {
+		return err
+	}
+
+	var request RetryRequest
+	if err = ctx.ShouldBind(&request); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("invalid request")
+	}
+
+	changes := map[string]interface{}{"scheduled_at": nil}
+
+	if !request.ScheduledAt.IsZero() {
+		if request.ScheduledAt.Before(time.Now()) {
+			return errors.New("scheduled_at must be in the future")
+		}
+
+		changes["scheduled_at"] = request.ScheduledAt
+	}
+
+	if request.EmployeeID != 0 && request.EmployeeID != order.EmployeeID {
+		employee, err := o.repository.FindEmployee(loginData.OrganizationID, request.EmployeeID)
+		if err != nil {
+			return errors.New("employee not found")
+		}
+
+		if !rbac.Can(loginData.OrganizationID, employee.Role, rbac.OrderDeliver) {
+			return errors.New("this user can not deliver orders")
+		}
+
+		changes["employee_id"] = request.EmployeeID
+	}
+
+	history := newHistory(loginData, order, ActionRetry)
+	history.Note = request.Note
+
+	return o.repository.Transition(loginData.OrganizationID, int(order.ID), transition, history, changes)
+}
+
+// publicDir is where uploaded photos are stored and served from.
+var publicDir = "./public"
+
+// saveUpload stores an uploaded photo in the public folder under a hashed
+// name and returns that name.
+func saveUpload(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", errors.New("unable to get file from form")
+	}
 	defer file.Close()
 
 	// Hash the file name
-	fileName := HashFilename(header.Filename)
+	fileName, err := HashFilename(header.Filename)
+	if err != nil {
+		return "", errors.New("unable to name file")
+	}
 
 	// Save the file to the public folder
-	publicDir := "./public"
 	if err = os.MkdirAll(publicDir, os.ModePerm); err != nil {
-		return errors.New("unable to create public directory")
+		return "", errors.New("unable to create public directory")
 	}
 	dst, err := os.Create(filepath.Join(publicDir, fileName))
 	if err != nil {
-		return errors.New("unable to create file on server")
+		return "", errors.New("unable to create file on server")
 	}
 	defer dst.Close()
 
 	if _, err = io.Copy(dst, file); err != nil {
-		return errors.New("unable to save file")
+		return "", errors.New("unable to save file")
 	}
 
-	// Get IP address of the requester
-	ip := ctx.ClientIP()
+	return fileName, nil
+}
 
-	if err := o.repository.Success(id, ip, fileName); err != nil {
-		return err
+// removeUpload deletes a file stored by saveUpload whose transition failed.
+func removeUpload(fileName string) {
+	if err := os.Remove(filepath.Join(publicDir, fileName)); err != nil && !os.IsNotExist(err) {
+		log.Printf("orders: remove upload %s: %v", fileName, err)
+	}
+}
+
+// History implements Service. Without order:read:all only the history of
+// orders assigned to the caller can be read.
+func (o *orderServices) History(ctx *gin.Context) (result []OrderHistory, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID format")
+	}
+
+	order, err := o.repository.GetByID(loginData.OrganizationID, id)
+	if err != nil || (!loginData.Can(rbac.OrderReadAll) && order.EmployeeID != int(loginData.UserId)) {
+		return nil, errors.New("orders with ID does not exist")
+	}
+
+	return o.repository.GetHistory(loginData.OrganizationID, id)
 }
 
 func NewService(repository Repository) Service {
@@ -0,0 +1,154 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+)
+
+// Order statuses.
+const (
+	StatusPending   = "Pending"
+	StatusDelivery  = "Delivery"
+	StatusSuccess   = "Success"
+	StatusCancelled = "Cancelled"
+	StatusFailed    = "Failed"
+	StatusReturned  = "Returned"
+)
+
+// Actions recorded in the order history. Every action but ActionCreate is a
+// Transition.
+const (
+	ActionCreate   = "create"
+	ActionDeliver  = "deliver"
+	ActionComplete = "complete"
+	ActionCancel   = "cancel"
+	ActionFail     = "fail"
+	ActionReturn   = "return"
+	ActionRetry    = "retry"
+)
+
+// Transition moves an order from one of From to To. Only callers holding
+// Permission may trigger it, and Guard (when set) has the final say.
+// RequiresReason transitions need a reason code of the To status, see
+// OrderReason.
+type Transition struct {
+	Action         string
+	From           []string
+	To             string
+	Permission     string
+	Guard          func(order Order, loginData middlewares.UserLoginRedis) error
+	RequiresReason bool
+}
+
+// transitions is the order state machine:
+//
+//	Pending --deliver--> Delivery --complete--> Success
+//	Pending, Delivery --cancel--> Cancelled
+//	Delivery --fail--> Failed --retry--> Pending
+//	Delivery, Failed --return--> Returned
+var transitions = map[string]Transition{
+	ActionDeliver: {
+		Action:     ActionDeliver,
+		From:       []string{StatusPending},
+		To:         StatusDelivery,
+		Permission: rbac.OrderDeliver,
+		Guard:      assigneeOrDispatcher,
+	},
+	ActionComplete: {
+		Action:     ActionComplete,
+		From:       []string{StatusDelivery},
+		To:         StatusSuccess,
+		Permission: rbac.OrderComplete,
+		Guard:      assigneeOrDispatcher,
+	},
+	ActionCancel: {
+		Action:         ActionCancel,
+		From:           []string{StatusPending, StatusDelivery},
+		To:             StatusCancelled,
+		Permission:     rbac.OrderUpdate,
+		RequiresReason: true,
+	},
+	ActionFail: {
+		Action:         ActionFail,
+		From:           []string{StatusDelivery},
+		To:             StatusFailed,
+		Permission:     rbac.OrderDeliver,
+		Guard:          assigneeOrDispatcher,
+		RequiresReason: true,
+	},
+	ActionReturn: {
+		Action:         ActionReturn,
+		From:           []string{StatusDelivery, StatusFailed},
+		To:             StatusReturned,
+		Permission:     rbac.OrderDeliver,
+		Guard:          assigneeOrDispatcher,
+		RequiresReason: true,
+	},
+	ActionRetry: {
+		Action:     ActionRetry,
+		From:       []string{StatusFailed},
+		To:         StatusPending,
+		Permission: rbac.OrderUpdate,
+	},
+}
+
+// assigneeOrDispatcher lets only the assigned employee move the order, unless
+// the caller may edit orders anyway.
+func assigneeOrDispatcher(order Order, loginData middlewares.UserLoginRedis) error {
+	if order.EmployeeID == int(loginData.UserId) || loginData.Can(rbac.OrderUpdate) {
+		return nil
+	}
+
+	return errors.New("this order is assigned to another employee")
+}
+
+// ConflictError is returned when an action does not fit the current status
+// of the order, e.g. because a concurrent request moved it first.
+type ConflictError struct {
+	Action string
+	Status string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("cannot %s an order that is %s", e.Action, e.Status)
+}
+
+// FindTransition returns the transition of action.
+func FindTransition(action string) (Transition, error) {
+	transition, ok := transitions[action]
+	if !ok {
+		return Transition{}, fmt.Errorf("unknown order action %q", action)
+	}
+
+	return transition, nil
+}
+
+// Allows reports whether the transition may leave status.
+func (t Transition) Allows(status string) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check applies the permission, the current status and the guard.
+func (t Transition) Check(order Order, loginData middlewares.UserLoginRedis) error {
+	if !loginData.Can(t.Permission) {
+		return errors.New("you are not allowed to " + t.Action + " orders")
+	}
+
+	if !t.Allows(order.Status) {
+		return &ConflictError{Action: t.Action, Status: order.Status}
+	}
+
+	if t.Guard != nil {
+		return t.Guard(order, loginData)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package orders
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrVersionRequired is returned by edits that name no order version.
+	ErrVersionRequired = errors.New("version or If-Match header required, get the order first")
+
+	// ErrVersionMismatch is returned when the order changed since the
+	// version the edit was based on.
+	ErrVersionMismatch = errors.New("order was changed by someone else, reload it and try again")
+)
+
+// ETag is the entity tag of an order version.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag reads an order version from an If-Match header, accepting weak
+// and unquoted tags too.
+func parseETag(tag string) (int, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	version, err := strconv.Atoi(strings.Trim(tag, `"`))
+	if err != nil || version <= 0 {
+		return 0, errors.New("invalid If-Match, use the ETag of the order")
+	}
+
+	return version, nil
+}
+
+// expectedVersion is the version an edit is based on: the If-Match header,
+// else the version field of the body.
+func expectedVersion(ifMatch string, version int) (int, error) {
+	if ifMatch != "" {
+		return parseETag(ifMatch)
+	}
+
+	if version <= 0 {
+		return 0, ErrVersionRequired
+	}
+
+	return version, nil
+}
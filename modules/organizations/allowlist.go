@@ -0,0 +1,62 @@
+package organizations
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// allowlistCacheTTL bounds how long a replica keeps enforcing an allowlist
+// after it was changed on another replica.
+const allowlistCacheTTL = time.Minute
+
+type cachedAllowlist struct {
+	allowlist IPAllowlist
+	loadedAt  time.Time
+}
+
+var (
+	allowlistMu    sync.Mutex
+	allowlistCache = map[uint]cachedAllowlist{}
+)
+
+func forgetAllowlist(orgID uint) {
+	allowlistMu.Lock()
+	delete(allowlistCache, orgID)
+	allowlistMu.Unlock()
+}
+
+// AllowlistChecker implements middlewares.IPAllowlistChecker with the
+// allowlists stored on organizations.
+type AllowlistChecker struct {
+	repository Repository
+}
+
+func NewAllowlistChecker(database *gorm.DB) *AllowlistChecker {
+	return &AllowlistChecker{
+		repository: NewRepository(database),
+	}
+}
+
+// Allowed implements middlewares.IPAllowlistChecker.
+func (a *AllowlistChecker) Allowed(orgID uint, role, ip string) (bool, error) {
+	allowlistMu.Lock()
+	cached, found := allowlistCache[orgID]
+	allowlistMu.Unlock()
+
+	if !found || time.Since(cached.loadedAt) > allowlistCacheTTL {
+		organization, err := a.repository.FindByID(orgID)
+		if err != nil {
+			return false, err
+		}
+
+		cached = cachedAllowlist{allowlist: organization.IPAllowlist, loadedAt: time.Now()}
+
+		allowlistMu.Lock()
+		allowlistCache[orgID] = cached
+		allowlistMu.Unlock()
+	}
+
+	return cached.allowlist.Allows(role, ip), nil
+}
@@ -0,0 +1,135 @@
+package organizations
+
+import (
+	"errors"
+	"gotrack/database"
+	"gotrack/helpers/common"
+	"gotrack/helpers/password"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetCurrent godoc
+// @Summary Get current organization
+// @Description Get the organization of the logged in user
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Router /api/organizations/me [get]
+func GetCurrent(ctx *gin.Context) {
+	var (
+		organizationRepo = NewRepository(database.DBConnections)
+		organizationSrv  = NewService(organizationRepo)
+	)
+
+	data, err := organizationSrv.GetCurrent(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully Get Organization data", data)
+}
+
+// UpdateCurrent godoc
+// @Summary Update current organization
+// @Description Update the organization of the logged in user
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param organization body OrganizationRequest true "Organization data"
+// @Security Bearer
+// @Router /api/organizations/me [put]
+func UpdateCurrent(ctx *gin.Context) {
+	var (
+		organizationRepo = NewRepository(database.DBConnections)
+		organizationSrv  = NewService(organizationRepo)
+	)
+
+	err := organizationSrv.UpdateCurrent(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Organization data")
+}
+
+// UpdateSecurity godoc
+// @Summary Update organization security settings
+// @Description Update security settings such as requiring two-factor authentication for owners
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param security body SecurityRequest true "Security settings"
+// @Security Bearer
+// @Router /api/organizations/me/security [put]
+func UpdateSecurity(ctx *gin.Context) {
+	var (
+		organizationRepo = NewRepository(database.DBConnections)
+		organizationSrv  = NewService(organizationRepo)
+	)
+
+	err := organizationSrv.UpdateSecurity(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Organization security settings")
+}
+
+// UpdatePasswordPolicy godoc
+// @Summary Update organization password policy
+// @Description Replace the password policy enforced on sign up, password changes and password resets
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param policy body PasswordPolicyRequest true "Password policy"
+// @Security Bearer
+// @Router /api/organizations/me/password-policy [put]
+func UpdatePasswordPolicy(ctx *gin.Context) {
+	var (
+		organizationRepo = NewRepository(database.DBConnections)
+		organizationSrv  = NewService(organizationRepo)
+	)
+
+	err := organizationSrv.UpdatePasswordPolicy(ctx)
+	if err != nil {
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			common.GenerateErrorResponseWithData(ctx, err.Error(), policyErr.Violations)
+			return
+		}
+
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Organization password policy")
+}
+
+// UpdateIPAllowlist godoc
+// @Summary Update organization IP allowlist
+// @Description Restrict logins and privileged routes of the given roles (owner when empty) to CIDR ranges. An empty cidrs list removes the restriction
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param allowlist body IPAllowlistRequest true "IP allowlist"
+// @Security Bearer
+// @Router /api/organizations/me/ip-allowlist [put]
+func UpdateIPAllowlist(ctx *gin.Context) {
+	var (
+		organizationRepo = NewRepository(database.DBConnections)
+		organizationSrv  = NewService(organizationRepo)
+	)
+
+	err := organizationSrv.UpdateIPAllowlist(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Organization IP allowlist")
+}
@@ -0,0 +1,177 @@
+package organizations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/helpers/password"
+	"gotrack/helpers/rbac"
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Organization is the tenant every user, order and location belongs to.
+type Organization struct {
+	gorm.Model
+	Name                  string          `json:"name"`
+	RequireOwnerTwoFactor bool            `json:"require_owner_two_factor"`
+	PasswordPolicy        password.Policy `json:"password_policy" gorm:"embedded;embeddedPrefix:password_"`
+	IPAllowlist           IPAllowlist     `json:"ip_allowlist" gorm:"embedded;embeddedPrefix:ip_allowlist_"`
+}
+
+// IPAllowlist restricts logins and privileged routes of Roles (the owner role
+// when empty) to the CIDR ranges. An empty CIDRs list allows every address.
+type IPAllowlist struct {
+	CIDRs []string `json:"cidrs" gorm:"serializer:json"`
+	Roles []string `json:"roles" gorm:"serializer:json"`
+}
+
+// Applies reports whether the allowlist restricts role.
+func (l IPAllowlist) Applies(role string) bool {
+	if len(l.CIDRs) == 0 {
+		return false
+	}
+
+	if len(l.Roles) == 0 {
+		return role == rbac.OwnerRole
+	}
+
+	for _, restricted := range l.Roles {
+		if restricted == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains reports whether ip is in one of the ranges. Invalid entries never
+// match.
+func (l IPAllowlist) Contains(ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+
+	for _, cidr := range l.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows reports whether role may be used from ip.
+func (l IPAllowlist) Allows(role, ip string) bool {
+	return !l.Applies(role) || l.Contains(ip)
+}
+
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func (o *OrganizationRequest) ValidateOrganization() (err error) {
+	if common.IsEmptyField(o.Name) {
+		return errors.New("name required")
+	}
+
+	return
+}
+
+type SecurityRequest struct {
+	RequireOwnerTwoFactor *bool `json:"require_owner_two_factor"`
+}
+
+func (s *SecurityRequest) ConvertToSettings() map[string]interface{} {
+	settings := map[string]interface{}{}
+
+	if s.RequireOwnerTwoFactor != nil {
+		settings["require_owner_two_factor"] = *s.RequireOwnerTwoFactor
+	}
+
+	return settings
+}
+
+type IPAllowlistRequest struct {
+	IPAllowlist
+}
+
+// ValidateIPAllowlist normalizes bare addresses to single host ranges.
+func (i *IPAllowlistRequest) ValidateIPAllowlist(orgID uint) (err error) {
+	cidrs := make([]string, 0, len(i.CIDRs))
+	for _, cidr := range i.CIDRs {
+		cidr = strings.TrimSpace(cidr)
+
+		if address := net.ParseIP(cidr); address != nil {
+			if address.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, parseErr := net.ParseCIDR(cidr)
+		if parseErr != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+
+		cidrs = append(cidrs, network.String())
+	}
+	i.CIDRs = cidrs
+
+	if i.Roles == nil {
+		i.Roles = []string{}
+	}
+
+	for _, role := range i.Roles {
+		if !rbac.RoleExists(orgID, role) {
+			return fmt.Errorf("role %q does not exist", role)
+		}
+	}
+
+	return
+}
+
+func (i *IPAllowlistRequest) ConvertToSettings() (map[string]interface{}, error) {
+	cidrs, err := json.Marshal(i.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := json.Marshal(i.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Updates with a map skips serializers, so the JSON is written as is
+	return map[string]interface{}{
+		"ip_allowlist_cidrs": string(cidrs),
+		"ip_allowlist_roles": string(roles),
+	}, nil
+}
+
+type PasswordPolicyRequest struct {
+	password.Policy
+}
+
+// ConvertToSettings replaces the whole policy, so rules can be switched off.
+func (p *PasswordPolicyRequest) ConvertToSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"password_min_length":        p.MinLength,
+		"password_require_upper":     p.RequireUpper,
+		"password_require_lower":     p.RequireLower,
+		"password_require_digit":     p.RequireDigit,
+		"password_require_symbol":    p.RequireSymbol,
+		"password_disallow_username": p.DisallowUsername,
+		"password_disallow_common":   p.DisallowCommon,
+	}
+}
@@ -0,0 +1,74 @@
+package organizations
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	FindByID(id uint) (Organization, error)
+	Update(id uint, organization Organization) error
+	UpdateSettings(id uint, settings map[string]interface{}) error
+}
+
+type organizationRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(database *gorm.DB) Repository {
+	return &organizationRepository{
+		db: database,
+	}
+}
+
+// FindByID implements Repository.
+func (o *organizationRepository) FindByID(id uint) (organization Organization, err error) {
+	err = o.db.First(&organization, id).Error
+	return
+}
+
+// Update implements Repository.
+func (o *organizationRepository) Update(id uint, organization Organization) error {
+	return o.db.Model(&Organization{}).Where("id = ?", id).Updates(organization).Error
+}
+
+// UpdateSettings implements Repository. It takes a map so settings can be
+// switched off, which Updates with a struct would skip.
+func (o *organizationRepository) UpdateSettings(id uint, settings map[string]interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	return o.db.Model(&Organization{}).Where("id = ?", id).Updates(settings).Error
+}
+
+// HasIPAllowlist reports whether any organization restricts its roles to
+// networks.
+func HasIPAllowlist(db *gorm.DB) (bool, error) {
+	var count int64
+	err := db.Model(&Organization{}).
+		Where("ip_allowlist_cidrs IS NOT NULL AND ip_allowlist_cidrs NOT IN ('', '[]', 'null')").
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// EnsureDefault creates the first organization when there is none yet and
+// moves rows created before multi-tenancy (organization_id 0 or NULL) of the
+// given tables into it.
+func EnsureDefault(db *gorm.DB, tables ...string) error {
+	var organization Organization
+	if err := db.Order("id").Attrs(Organization{Name: "Default"}).FirstOrCreate(&organization).Error; err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		err := db.Exec(fmt.Sprintf("UPDATE %s SET organization_id = ? WHERE organization_id IS NULL OR organization_id = 0", table), organization.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
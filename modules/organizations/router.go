@@ -0,0 +1,21 @@
+package organizations
+
+import (
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Initiator(router *gin.Engine) {
+	api := router.Group("/api/organizations")
+	api.Use(middlewares.JwtMiddleware())
+	api.Use(middlewares.Logging())
+	{
+		api.GET("/me", GetCurrent)
+		api.PUT("/me", middlewares.RequirePermission(rbac.OrganizationManage), UpdateCurrent)
+		api.PUT("/me/security", middlewares.RequirePermission(rbac.OrganizationManage), UpdateSecurity)
+		api.PUT("/me/password-policy", middlewares.RequirePermission(rbac.OrganizationManage), UpdatePasswordPolicy)
+		api.PUT("/me/ip-allowlist", middlewares.RequirePermission(rbac.OrganizationManage), UpdateIPAllowlist)
+	}
+}
@@ -0,0 +1,136 @@
+package organizations
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/audit"
+	"gotrack/middlewares"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Service interface {
+	GetCurrent(ctx *gin.Context) (result Organization, err error)
+	UpdateCurrent(ctx *gin.Context) (err error)
+	UpdateSecurity(ctx *gin.Context) (err error)
+	UpdatePasswordPolicy(ctx *gin.Context) (err error)
+	UpdateIPAllowlist(ctx *gin.Context) (err error)
+}
+
+type organizationServices struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return &organizationServices{
+		repository,
+	}
+}
+
+// GetCurrent implements Service.
+func (o *organizationServices) GetCurrent(ctx *gin.Context) (result Organization, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	return o.repository.FindByID(loginData.OrganizationID)
+}
+
+// UpdateCurrent implements Service.
+func (o *organizationServices) UpdateCurrent(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request OrganizationRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.ValidateOrganization(); err != nil {
+		return
+	}
+
+	return o.repository.Update(loginData.OrganizationID, Organization{Name: request.Name})
+}
+
+// UpdateSecurity implements Service.
+func (o *organizationServices) UpdateSecurity(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request SecurityRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	return o.repository.UpdateSettings(loginData.OrganizationID, request.ConvertToSettings())
+}
+
+// UpdatePasswordPolicy implements Service.
+func (o *organizationServices) UpdatePasswordPolicy(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request PasswordPolicyRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.Validate(); err != nil {
+		return
+	}
+
+	return o.repository.UpdateSettings(loginData.OrganizationID, request.ConvertToSettings())
+}
+
+// UpdateIPAllowlist implements Service. It refuses lists that would lock the
+// caller out right away.
+func (o *organizationServices) UpdateIPAllowlist(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request IPAllowlistRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.ValidateIPAllowlist(loginData.OrganizationID); err != nil {
+		return
+	}
+
+	if len(request.CIDRs) > 0 && !middlewares.ProxiesConfigured() {
+		return errors.New("client addresses are not known until TRUSTED_PROXIES is configured, allowlists cannot be enabled")
+	}
+
+	if !request.IPAllowlist.Allows(loginData.Role, ctx.ClientIP()) {
+		return fmt.Errorf("your address %s is not in the allowlist, you would lock yourself out", ctx.ClientIP())
+	}
+
+	settings, err := request.ConvertToSettings()
+	if err != nil {
+		return
+	}
+
+	if err = o.repository.UpdateSettings(loginData.OrganizationID, settings); err != nil {
+		return
+	}
+
+	forgetAllowlist(loginData.OrganizationID)
+
+	entry := middlewares.AuditEntry(ctx, audit.OrganizationIPAllowlist)
+	entry.TargetType = "organization"
+	entry.TargetID = strconv.FormatUint(uint64(loginData.OrganizationID), 10)
+	audit.Record(entry, map[string]interface{}{"cidrs": request.CIDRs, "roles": request.Roles})
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package roles
+
+import (
+	"gotrack/database"
+	"gotrack/helpers/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAll godoc
+// @Summary Get all roles
+// @Description Get all roles with their permissions
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Router /api/roles [get]
+func GetAll(ctx *gin.Context) {
+	var (
+		roleRepo = NewRepository(database.DBConnections)
+		roleSrv  = NewService(roleRepo)
+	)
+
+	data, err := roleSrv.GetAll(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Role data", int64(len(data)), data)
+}
+
+// Create godoc
+// @Summary Create a new role
+// @Description Creates a new role with the given permissions
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param role body RoleRequest true "Role data"
+// @Security Bearer
+// @Router /api/roles [post]
+func Create(ctx *gin.Context) {
+	var (
+		roleRepo = NewRepository(database.DBConnections)
+		roleSrv  = NewService(roleRepo)
+	)
+
+	err := roleSrv.Create(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully added Role data")
+}
+
+// UpdatePermissions godoc
+// @Summary Update role permissions
+// @Description Replaces the permission set of a role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param name path string true "Role name"
+// @Param permissions body PermissionsRequest true "Permissions"
+// @Security Bearer
+// @Router /api/roles/{name}/permissions [put]
+func UpdatePermissions(ctx *gin.Context) {
+	var (
+		roleRepo = NewRepository(database.DBConnections)
+		roleSrv  = NewService(roleRepo)
+	)
+
+	err := roleSrv.UpdatePermissions(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully updated Role permissions")
+}
+
+// Delete godoc
+// @Summary Delete a role
+// @Description Remove a role that is no longer assigned to any user
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param name path string true "Role name"
+// @Security Bearer
+// @Router /api/roles/{name} [delete]
+func Delete(ctx *gin.Context) {
+	var (
+		roleRepo = NewRepository(database.DBConnections)
+		roleSrv  = NewService(roleRepo)
+	)
+
+	err := roleSrv.Delete(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully delete role")
+}
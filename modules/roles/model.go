@@ -0,0 +1,75 @@
+package roles
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"regexp"
+)
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *RoleRequest) ValidateRole() (err error) {
+	if common.IsEmptyField(r.Name) {
+		return errors.New("name required")
+	}
+
+	re := regexp.MustCompile(`^[a-z][a-z0-9_]{1,19}$`)
+	if !re.MatchString(r.Name) {
+		return errors.New("name must be 2-20 lowercase letters, digits or underscores")
+	}
+
+	return validatePermissions(r.Permissions)
+}
+
+type PermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+func (p *PermissionsRequest) ValidatePermissions() (err error) {
+	return validatePermissions(p.Permissions)
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !rbac.IsPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+
+	return nil
+}
+
+func (r *RoleRequest) ConvertToModel(orgID uint) rbac.Role {
+	role := rbac.Role{
+		OrganizationID: orgID,
+		Name:           r.Name,
+		Description:    r.Description,
+	}
+
+	for _, permission := range uniquePermissions(r.Permissions) {
+		role.Permissions = append(role.Permissions, rbac.RolePermission{Permission: permission})
+	}
+
+	return role
+}
+
+func uniquePermissions(permissions []string) []string {
+	seen := make(map[string]bool, len(permissions))
+	result := make([]string, 0, len(permissions))
+
+	for _, permission := range permissions {
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		result = append(result, permission)
+	}
+
+	return result
+}
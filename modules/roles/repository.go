@@ -0,0 +1,98 @@
+package roles
+
+import (
+	"gotrack/helpers/rbac"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	GetAll(orgID uint) (result []rbac.Role, err error)
+	FindByName(orgID uint, name string) (rbac.Role, error)
+	Create(role *rbac.Role) error
+	ReplacePermissions(roleID uint, permissions []string) error
+	Delete(roleID uint) error
+	CountUsers(orgID uint, name string) (int64, error)
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(database *gorm.DB) Repository {
+	return &roleRepository{
+		db: database,
+	}
+}
+
+// GetAll implements Repository.
+func (r *roleRepository) GetAll(orgID uint) (result []rbac.Role, err error) {
+	err = r.db.Preload("Permissions").Where("organization_id = ?", orgID).Order("name").Find(&result).Error
+	return
+}
+
+// FindByName implements Repository.
+func (r *roleRepository) FindByName(orgID uint, name string) (role rbac.Role, err error) {
+	err = r.db.Preload("Permissions").Where("organization_id = ? AND name = ?", orgID, name).First(&role).Error
+	return
+}
+
+// Create implements Repository.
+func (r *roleRepository) Create(role *rbac.Role) error {
+	return r.db.Create(role).Error
+}
+
+// ReplacePermissions implements Repository. Revoked permissions are soft
+// deleted and restored when granted again, see rbac.RolePermission.
+func (r *roleRepository) ReplacePermissions(roleID uint, permissions []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("role_id = ?", roleID)
+		if len(permissions) > 0 {
+			query = query.Where("permission NOT IN ?", permissions)
+		}
+		if err := query.Delete(&rbac.RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			var existing rbac.RolePermission
+			err := tx.Unscoped().Where("role_id = ? AND permission = ?", roleID, permission).First(&existing).Error
+			if err == gorm.ErrRecordNotFound {
+				if err = tx.Create(&rbac.RolePermission{RoleID: roleID, Permission: permission}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if existing.DeletedAt.Valid {
+				if err = tx.Unscoped().Model(&existing).Update("deleted_at", nil).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete implements Repository.
+func (r *roleRepository) Delete(roleID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("role_id = ?", roleID).Delete(&rbac.RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&rbac.Role{}, roleID).Error
+	})
+}
+
+// CountUsers implements Repository.
+func (r *roleRepository) CountUsers(orgID uint, name string) (count int64, err error) {
+	err = r.db.Table("users").
+		Where("organization_id = ? AND role = ? AND deleted_at IS NULL", orgID, name).
+		Count(&count).Error
+	return
+}
@@ -0,0 +1,21 @@
+package roles
+
+import (
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Initiator(router *gin.Engine) {
+	api := router.Group("/api/roles")
+	api.Use(middlewares.JwtMiddleware())
+	api.Use(middlewares.Logging())
+	api.Use(middlewares.RequirePermission(rbac.RoleManage))
+	{
+		api.GET("", GetAll)
+		api.POST("", Create)
+		api.PUT(":name/permissions", UpdatePermissions)
+		api.DELETE(":name", Delete)
+	}
+}
@@ -0,0 +1,141 @@
+package roles
+
+import (
+	"errors"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Service interface {
+	GetAll(ctx *gin.Context) (result []rbac.Role, err error)
+	Create(ctx *gin.Context) (err error)
+	UpdatePermissions(ctx *gin.Context) (err error)
+	Delete(ctx *gin.Context) (err error)
+}
+
+type roleServices struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return &roleServices{
+		repository,
+	}
+}
+
+// GetAll implements Service.
+func (r *roleServices) GetAll(ctx *gin.Context) (result []rbac.Role, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.repository.GetAll(loginData.OrganizationID)
+}
+
+// Create implements Service.
+func (r *roleServices) Create(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	var request RoleRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.ValidateRole(); err != nil {
+		return err
+	}
+
+	if rbac.RoleExists(loginData.OrganizationID, request.Name) {
+		return errors.New("role already exists")
+	}
+
+	role := request.ConvertToModel(loginData.OrganizationID)
+	if err = r.repository.Create(&role); err != nil {
+		return err
+	}
+
+	return rbac.Reload()
+}
+
+// UpdatePermissions implements Service.
+func (r *roleServices) UpdatePermissions(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	role, err := r.findRole(loginData.OrganizationID, ctx.Param("name"))
+	if err != nil {
+		return err
+	}
+
+	if role.Name == rbac.OwnerRole {
+		return errors.New("owner role always has every permission")
+	}
+
+	var request PermissionsRequest
+	if err = ctx.ShouldBind(&request); err != nil {
+		return errors.New("invalid request")
+	}
+
+	if err = request.ValidatePermissions(); err != nil {
+		return err
+	}
+
+	if err = r.repository.ReplacePermissions(role.ID, uniquePermissions(request.Permissions)); err != nil {
+		return err
+	}
+
+	return rbac.Reload()
+}
+
+// Delete implements Service.
+func (r *roleServices) Delete(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	role, err := r.findRole(loginData.OrganizationID, ctx.Param("name"))
+	if err != nil {
+		return err
+	}
+
+	if _, isDefault := rbac.DefaultRoles[role.Name]; isDefault {
+		return errors.New("default roles can not be deleted")
+	}
+
+	count, err := r.repository.CountUsers(loginData.OrganizationID, role.Name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("role is still assigned to users")
+	}
+
+	if err = r.repository.Delete(role.ID); err != nil {
+		return err
+	}
+
+	return rbac.Reload()
+}
+
+func (r *roleServices) findRole(orgID uint, name string) (rbac.Role, error) {
+	role, err := r.repository.FindByName(orgID, name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return role, errors.New("role does not exist")
+		}
+		return role, err
+	}
+
+	return role, nil
+}
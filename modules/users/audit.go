@@ -0,0 +1,59 @@
+package users
+
+import (
+	"gotrack/helpers/audit"
+	"gotrack/middlewares"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditUser records an action on target by the logged in user. Without a
+// login (e.g. password reset) the entry goes to the target's organization.
+func auditUser(ctx *gin.Context, action string, target User, details map[string]interface{}) {
+	entry := middlewares.AuditEntry(ctx, action)
+
+	// unknown users (e.g. a failed login) are only named in the details
+	if target.ID != 0 {
+		entry.TargetType = "user"
+		entry.TargetID = strconv.FormatUint(uint64(target.ID), 10)
+	}
+
+	if entry.OrganizationID == 0 {
+		entry.OrganizationID = target.OrganizationID
+	}
+
+	audit.Record(entry, details)
+}
+
+// auditSelf records an action a user did on their own account before a
+// session exists, such as logging in.
+func auditSelf(ctx *gin.Context, action string, user User, details map[string]interface{}) {
+	entry := middlewares.AuditEntry(ctx, action)
+	entry.OrganizationID = user.OrganizationID
+	entry.ActorID = user.ID
+	entry.ActorName = user.Username
+	entry.TargetType = "user"
+	entry.TargetID = strconv.FormatUint(uint64(user.ID), 10)
+
+	audit.Record(entry, details)
+}
+
+// updatedFields names the fields an update sets, never their values.
+func updatedFields(request *UpdatePayload) []string {
+	fields := []string{}
+	if request.Username != "" {
+		fields = append(fields, "username")
+	}
+	if request.Email != "" {
+		fields = append(fields, "email")
+	}
+	if request.Password != "" {
+		fields = append(fields, "password")
+	}
+	if request.Role != "" {
+		fields = append(fields, "role")
+	}
+
+	return fields
+}
@@ -1,8 +1,12 @@
 package users
 
 import (
+	"errors"
 	"gotrack/database"
 	"gotrack/helpers/common"
+	"gotrack/helpers/password"
+	"gotrack/middlewares"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -30,6 +34,18 @@ func Login(ctx *gin.Context) {
 	common.GenerateSuccessResponseWithData(ctx, "successfully login", token)
 }
 
+// generatePasswordErrorResponse adds the policy violations to the response
+// of endpoints that set a password.
+func generatePasswordErrorResponse(ctx *gin.Context, err error) {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		common.GenerateErrorResponseWithData(ctx, err.Error(), policyErr.Violations)
+		return
+	}
+
+	common.GenerateErrorResponse(ctx, err.Error())
+}
+
 // SignUp godoc
 // @Tags Users
 // @Summary User Signup
@@ -47,7 +63,7 @@ func SignUp(ctx *gin.Context) {
 
 	err := userSrv.SignUpService(ctx)
 	if err != nil {
-		common.GenerateErrorResponse(ctx, err.Error())
+		generatePasswordErrorResponse(ctx, err)
 		return
 	}
 
@@ -122,7 +138,7 @@ func Update(ctx *gin.Context) {
 
 	err := userSrv.Update(ctx)
 	if err != nil {
-		common.GenerateErrorResponse(ctx, err.Error())
+		generatePasswordErrorResponse(ctx, err)
 		return
 	}
 
@@ -176,3 +192,595 @@ func Track(ctx *gin.Context) {
 
 	common.GenerateSuccessResponseWithData(ctx, "awesome, successfully create user", data)
 }
+
+// Logout godoc
+// @Tags Users
+// @Summary User Logout
+// @Description This endpoint revokes the token used for the request
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Router /api/users/logout [post]
+func Logout(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.Logout(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully logout")
+}
+
+// RevokeSessions godoc
+// @Tags Users
+// @Summary Revoke user sessions
+// @Description Revoke every active session of a user
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Security Bearer
+// @Router /api/users/{id}/sessions/revoke [post]
+func RevokeSessions(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.RevokeSessions(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully revoke user sessions")
+}
+
+// Refresh godoc
+// @Tags Users
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token and a rotated refresh token
+// @Accept json
+// @Produce json
+// @Param refreshRequest body RefreshRequest true "Refresh Request"
+// @Router /api/users/token/refresh [post]
+func Refresh(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	token, err := userSrv.Refresh(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully refresh token", token)
+}
+
+// JWKS godoc
+// @Tags Users
+// @Summary JSON Web Key Set
+// @Description Public keys other services can use to verify GoTrack tokens
+// @Produce json
+// @Router /.well-known/jwks.json [get]
+func JWKS(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, middlewares.Keys.JWKS())
+}
+
+// RegisterOrganization godoc
+// @Tags Users
+// @Summary Register organization
+// @Description Creates a new organization together with its first owner account
+// @Accept json
+// @Produce json
+// @Param registerRequest body RegisterOrganizationRequest true "Register Request"
+// @Router /api/users/register [post]
+func RegisterOrganization(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.RegisterOrganization(ctx)
+	if err != nil {
+		generatePasswordErrorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "awesome, successfully register organization")
+}
+
+// Unlock godoc
+// @Tags Users
+// @Summary Unlock user login
+// @Description Clear the failed login counter and lockout of a user and of the address they last logged in from
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param unlockRequest body UnlockRequest false "Another address to unlock"
+// @Security Bearer
+// @Router /api/users/{id}/unlock [post]
+func Unlock(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.Unlock(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully unlock user")
+}
+
+// LoginTwoFactor godoc
+// @Tags Users
+// @Summary Complete login with a second factor
+// @Description Exchange the challenge token returned by login and a TOTP or recovery code for a session
+// @Accept json
+// @Produce json
+// @Param twoFactorRequest body TwoFactorLoginRequest true "Two-factor Request"
+// @Router /api/users/login/2fa [post]
+func LoginTwoFactor(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	token, err := userSrv.LoginTwoFactor(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully login", token)
+}
+
+// EnrollTwoFactorLogin godoc
+// @Tags Users
+// @Summary Enroll two-factor authentication during login
+// @Description Returns a TOTP secret, otpauth URI and recovery codes for a user whose organization requires 2FA
+// @Accept json
+// @Produce json
+// @Param challengeRequest body ChallengeRequest true "Challenge Request"
+// @Router /api/users/login/2fa/enroll [post]
+func EnrollTwoFactorLogin(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	enrollment, err := userSrv.EnrollTwoFactorLogin(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully enroll two-factor authentication", enrollment)
+}
+
+// EnrollTwoFactor godoc
+// @Tags Users
+// @Summary Enroll two-factor authentication
+// @Description Returns a new TOTP secret, otpauth URI and recovery codes. Confirm it with a code to enable it
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Router /api/users/me/2fa/enroll [post]
+func EnrollTwoFactor(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	enrollment, err := userSrv.EnrollTwoFactor(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully enroll two-factor authentication", enrollment)
+}
+
+// ConfirmTwoFactor godoc
+// @Tags Users
+// @Summary Confirm two-factor authentication
+// @Description Enables two-factor authentication with a code from the authenticator app
+// @Accept json
+// @Produce json
+// @Param codeRequest body TwoFactorCodeRequest true "Code Request"
+// @Security Bearer
+// @Router /api/users/me/2fa/confirm [post]
+func ConfirmTwoFactor(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.ConfirmTwoFactor(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully enable two-factor authentication")
+}
+
+// DisableTwoFactor godoc
+// @Tags Users
+// @Summary Disable two-factor authentication
+// @Description Disables two-factor authentication with a current code
+// @Accept json
+// @Produce json
+// @Param codeRequest body TwoFactorCodeRequest true "Code Request"
+// @Security Bearer
+// @Router /api/users/me/2fa [delete]
+func DisableTwoFactor(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.DisableTwoFactor(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully disable two-factor authentication")
+}
+
+// ForgotPassword godoc
+// @Tags Users
+// @Summary Request a password reset
+// @Description Sends a single-use reset link to the email of the account, if it has one
+// @Accept json
+// @Produce json
+// @Param forgotPasswordRequest body ForgotPasswordRequest true "Forgot Password Request"
+// @Router /api/users/password/forgot [post]
+func ForgotPassword(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.ForgotPassword(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "if the account exists, a reset link has been sent")
+}
+
+// ResetPassword godoc
+// @Tags Users
+// @Summary Reset password
+// @Description Sets a new password with a reset token and logs out every session of the user
+// @Accept json
+// @Produce json
+// @Param resetPasswordRequest body ResetPasswordRequest true "Reset Password Request"
+// @Router /api/users/password/reset [post]
+func ResetPassword(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.ResetPassword(ctx)
+	if err != nil {
+		generatePasswordErrorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully reset password")
+}
+
+// ListMySessions godoc
+// @Tags Users
+// @Summary List my sessions
+// @Description List the active logins of the current user with client IP and device
+// @Produce json
+// @Security Bearer
+// @Router /api/users/me/sessions [get]
+func ListMySessions(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.ListMySessions(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully get sessions", int64(len(data)), data)
+}
+
+// RevokeMySession godoc
+// @Tags Users
+// @Summary Revoke one of my sessions
+// @Description Log out one login of the current user, e.g. a lost phone
+// @Produce json
+// @Param sessionId path string true "Session ID"
+// @Security Bearer
+// @Router /api/users/me/sessions/{sessionId} [delete]
+func RevokeMySession(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.RevokeMySession(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully revoke session")
+}
+
+// ListSessions godoc
+// @Tags Users
+// @Summary List sessions of a user
+// @Description List the active logins of a user with client IP and device
+// @Produce json
+// @Param id path int true "User ID"
+// @Security Bearer
+// @Router /api/users/{id}/sessions [get]
+func ListSessions(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.ListSessions(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully get sessions", int64(len(data)), data)
+}
+
+// RevokeSession godoc
+// @Tags Users
+// @Summary Revoke a session of a user
+// @Description Log out one login of a user
+// @Produce json
+// @Param id path int true "User ID"
+// @Param sessionId path string true "Session ID"
+// @Security Bearer
+// @Router /api/users/{id}/sessions/{sessionId} [delete]
+func RevokeSession(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.RevokeSession(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully revoke session")
+}
+
+// OIDCLogin godoc
+// @Tags Users
+// @Summary Single sign-on login
+// @Description Redirects to the identity provider (OpenID Connect authorization code flow with PKCE)
+// @Router /api/users/oidc/login [get]
+func OIDCLogin(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	authURL, err := userSrv.OIDCLogin(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Tags Users
+// @Summary Single sign-on callback
+// @Description The identity provider redirects here; returns the same tokens as the password login
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Router /api/users/oidc/callback [get]
+func OIDCCallback(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	token, err := userSrv.OIDCCallback(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully login", token)
+}
+
+// CreateInvitation godoc
+// @Tags Users
+// @Summary Invite a user
+// @Description Creates a single-use invitation with a role. The token is only shown in this response and mailed when an email is given
+// @Accept json
+// @Produce json
+// @Param invitationRequest body InvitationRequest true "Invitation Request"
+// @Security Bearer
+// @Router /api/users/invitations [post]
+func CreateInvitation(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.CreateInvitation(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully create invitation", data)
+}
+
+// ListInvitations godoc
+// @Tags Users
+// @Summary List pending invitations
+// @Description List the invitations of the organization that can still be accepted
+// @Produce json
+// @Security Bearer
+// @Router /api/users/invitations [get]
+func ListInvitations(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.ListInvitations(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Invitation data", int64(len(data)), data)
+}
+
+// RevokeInvitation godoc
+// @Tags Users
+// @Summary Revoke an invitation
+// @Description Revokes a pending invitation so its token can no longer be used
+// @Produce json
+// @Param invitationId path int true "Invitation ID"
+// @Security Bearer
+// @Router /api/users/invitations/{invitationId} [delete]
+func RevokeInvitation(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.RevokeInvitation(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "successfully revoke invitation")
+}
+
+// AcceptInvitation godoc
+// @Tags Users
+// @Summary Accept an invitation
+// @Description Creates the invited account with a username and password of the user's choice
+// @Accept json
+// @Produce json
+// @Param acceptInvitationRequest body AcceptInvitationRequest true "Accept Invitation Request"
+// @Router /api/users/invitations/accept [post]
+func AcceptInvitation(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	err := userSrv.AcceptInvitation(ctx)
+	if err != nil {
+		generatePasswordErrorResponse(ctx, err)
+		return
+	}
+
+	common.GenerateSuccessResponse(ctx, "awesome, successfully create user")
+}
+
+// Impersonate godoc
+// @Tags Users
+// @Summary Impersonate a user
+// @Description Issues a short, non-refreshable access token acting as the user to see what they see. Requests made with it return the X-Impersonator-ID header and are audited with the impersonator
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param impersonationRequest body ImpersonationRequest false "Impersonation Request"
+// @Security Bearer
+// @Router /api/users/{id}/impersonate [post]
+func Impersonate(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.Impersonate(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithData(ctx, "successfully start impersonation", data)
+}
+
+// ListLoginHistory godoc
+// @Tags Users
+// @Summary Login history of a user
+// @Description List the successful logins of a user with IP, location, device and anomalies, newest first
+// @Produce json
+// @Param id path int true "User ID"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Security Bearer
+// @Router /api/users/{id}/logins [get]
+func ListLoginHistory(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.ListLoginHistory(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Login History data", int64(len(data)), data)
+}
+
+// ListLoginAnomalies godoc
+// @Tags Users
+// @Summary Unusual logins
+// @Description List the logins of the organization flagged as new_country, impossible_travel or new_device, newest first
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Security Bearer
+// @Router /api/users/logins/anomalies [get]
+func ListLoginAnomalies(ctx *gin.Context) {
+	var (
+		userRepo = NewRepository(database.DBConnections)
+		userSrv  = NewService(userRepo)
+	)
+
+	data, err := userSrv.ListLoginAnomalies(ctx)
+	if err != nil {
+		common.GenerateErrorResponse(ctx, err.Error())
+		return
+	}
+
+	common.GenerateSuccessResponseWithListData(ctx, "successfully Get Login Anomaly data", int64(len(data)), data)
+}
@@ -0,0 +1,111 @@
+package users
+
+import (
+	"errors"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultImpersonationTTL applies when the request has no minutes.
+var DefaultImpersonationTTL = 15 * time.Minute
+
+// Impersonate implements Service. It issues an access token acting as the
+// :id user that carries the caller as impersonator. There is no refresh
+// token, so the session ends at ExpiredAt or on logout.
+func (service *UserService) Impersonate(ctx *gin.Context) (result ImpersonationResponse, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	if loginData.APIKeyID != 0 {
+		err = errors.New("impersonation requires a user login")
+		return
+	}
+
+	var request ImpersonationRequest
+
+	// the body is optional
+	if err = ctx.ShouldBind(&request); err != nil && !errors.Is(err, io.EOF) {
+		err = errors.New("invalid request")
+		return
+	}
+
+	ttl := DefaultImpersonationTTL
+	if request.Minutes != 0 {
+		ttl = time.Duration(request.Minutes) * time.Minute
+	}
+	if ttl <= 0 || ttl > middlewares.MaxImpersonationTTL {
+		err = errors.New("minutes must be between 1 and 60")
+		return
+	}
+
+	user, err := service.userFromParam(ctx, loginData.OrganizationID)
+	if err != nil {
+		return
+	}
+
+	if user.ID == uint(loginData.UserId) {
+		err = errors.New("you cannot impersonate yourself")
+		return
+	}
+
+	// acting as someone who may do more would be a privilege escalation, so
+	// the caller must hold every permission of the target's role
+	if user.Role == rbac.OwnerRole || rbac.Can(user.OrganizationID, user.Role, rbac.UserImpersonate) ||
+		!rbac.Covers(user.OrganizationID, loginData.Role, user.Role) {
+		err = errors.New("this user cannot be impersonated")
+		return
+	}
+
+	tokenID, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return
+	}
+
+	session := middlewares.UserLoginRedis{
+		TokenID:          tokenID,
+		UserId:           int64(user.ID),
+		OrganizationID:   user.OrganizationID,
+		Username:         user.Username,
+		Role:             user.Role,
+		UserAgent:        userAgent(ctx),
+		ClientIP:         ctx.ClientIP(),
+		LoginAt:          time.Now(),
+		ExpiredAt:        time.Now().Add(ttl),
+		ImpersonatorID:   loginData.UserId,
+		ImpersonatorName: loginData.Username,
+	}
+
+	token, err := middlewares.GenerateJwtToken(session)
+	if err != nil {
+		return
+	}
+
+	if err = middlewares.Sessions.Put(tokenID, session); err != nil {
+		err = errors.New("unable to store session")
+		return
+	}
+
+	auditUser(ctx, audit.ImpersonationStart, user, map[string]interface{}{
+		"token_id":   tokenID,
+		"expired_at": session.ExpiredAt,
+	})
+
+	result = ImpersonationResponse{
+		Token:          token,
+		ExpiredAt:      session.ExpiredAt,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Role:           user.Role,
+		ImpersonatorID: uint(loginData.UserId),
+	}
+
+	return
+}
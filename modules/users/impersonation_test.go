@@ -0,0 +1,98 @@
+package users
+
+import (
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// impersonationRepository finds the users of the impersonation tests.
+type impersonationRepository struct {
+	Repository
+
+	users []User
+}
+
+func (r *impersonationRepository) FindByID(orgID uint, id uint) (User, error) {
+	for _, user := range r.users {
+		if user.OrganizationID == orgID && user.ID == id {
+			return user, nil
+		}
+	}
+
+	return User{}, gorm.ErrRecordNotFound
+}
+
+func permissions(names ...string) []rbac.RolePermission {
+	result := make([]rbac.RolePermission, 0, len(names))
+	for _, name := range names {
+		result = append(result, rbac.RolePermission{Permission: name})
+	}
+	return result
+}
+
+func TestImpersonateRequiresEveryPermissionOfTarget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("jwt_secret_key", "test-secret")
+	if err := middlewares.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	rbac.Load([]rbac.Role{
+		{OrganizationID: 1, Name: rbac.OwnerRole, Permissions: permissions(rbac.AllPermissions...)},
+		// a custom role that may impersonate but not delete orders
+		{OrganizationID: 1, Name: "helpdesk", Permissions: permissions(rbac.UserImpersonate, rbac.UserRead, rbac.OrderReadAll)},
+		{OrganizationID: 1, Name: "supervisor", Permissions: permissions(rbac.OrderReadAll, rbac.OrderDelete, rbac.UserRead)},
+		{OrganizationID: 1, Name: "auditor", Permissions: permissions(rbac.OrderReadAll, rbac.UserRead)},
+	})
+
+	service := &UserService{repository: &impersonationRepository{users: []User{
+		{Model: gorm.Model{ID: 2}, OrganizationID: 1, Username: "sam", Role: "supervisor"},
+		{Model: gorm.Model{ID: 3}, OrganizationID: 1, Username: "alex", Role: "auditor"},
+	}}}
+
+	tests := []struct {
+		name    string
+		role    string
+		target  string
+		allowed bool
+	}{
+		{"missing a permission of the target", "helpdesk", "2", false},
+		{"holding every permission of the target", "helpdesk", "3", true},
+		{"owner", rbac.OwnerRole, "2", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/api/users/"+test.target+"/impersonate", nil)
+			ctx.Params = gin.Params{{Key: "id", Value: test.target}}
+			ctx.Set("auth", middlewares.UserLoginRedis{
+				TokenID:        "impersonator",
+				UserId:         1,
+				OrganizationID: 1,
+				Username:       "impersonator",
+				Role:           test.role,
+				ExpiredAt:      time.Now().Add(time.Minute),
+			})
+
+			_, err := service.Impersonate(ctx)
+			if test.allowed {
+				if err != nil {
+					t.Errorf("Impersonate: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != "this user cannot be impersonated" {
+				t.Errorf("err = %v, want the impersonation to be refused", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/common"
+	"gotrack/helpers/notifier"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	// DefaultInvitationTTL applies when an invitation has no expires_at.
+	DefaultInvitationTTL = 7 * 24 * time.Hour
+	// MaxInvitationTTL bounds how long an invitation can stay open.
+	MaxInvitationTTL = 30 * 24 * time.Hour
+)
+
+var errInvitationInvalid = errors.New("invitation invalid, expired or already used")
+
+func invitationLink(token string) string {
+	return tokenLink(os.Getenv("INVITATION_URL"), token)
+}
+
+func auditInvitation(ctx *gin.Context, action string, invitationID uint, details map[string]interface{}) {
+	entry := middlewares.AuditEntry(ctx, action)
+	entry.TargetType = "invitation"
+	entry.TargetID = strconv.FormatUint(uint64(invitationID), 10)
+
+	audit.Record(entry, details)
+}
+
+// CreateInvitation implements Service. The token is returned so the owner
+// can pass it on; it is also mailed when the invitation has an email.
+func (service *UserService) CreateInvitation(ctx *gin.Context) (result CreatedInvitation, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request InvitationRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		err = errors.New("invalid request")
+		return
+	}
+
+	if err = request.ValidateInvitation(); err != nil {
+		return
+	}
+
+	if !rbac.RoleExists(loginData.OrganizationID, request.Role) {
+		err = errors.New("role does not exist")
+		return
+	}
+
+	token, err := common.GenerateRandomToken(32)
+	if err != nil {
+		return
+	}
+
+	expiredAt := time.Now().Add(DefaultInvitationTTL)
+	if request.ExpiresAt != nil {
+		expiredAt = *request.ExpiresAt
+	}
+
+	invitation := Invitation{
+		OrganizationID: loginData.OrganizationID,
+		InvitedBy:      uint(loginData.UserId),
+		Email:          request.Email,
+		Role:           request.Role,
+		TokenHash:      common.HashToken(token),
+		ExpiredAt:      expiredAt,
+	}
+
+	if err = service.repository.CreateInvitation(&invitation); err != nil {
+		err = errors.New("unable to store invitation")
+		return
+	}
+
+	result.Invitation = invitation
+	result.Token = token
+	result.Link = invitationLink(token)
+
+	if invitation.Email != "" {
+		err = notifier.Send(notifier.Message{
+			To:      invitation.Email,
+			Subject: "You are invited to GoTrack",
+			Body: fmt.Sprintf("Hi,\n\n%s invited you to join GoTrack. Use the link below to create your account before %s.\n\n%s",
+				loginData.Username, expiredAt.Format("2 January 2006 15:04 MST"), result.Link),
+		})
+		if err != nil {
+			// the owner still gets the token and can pass it on
+			log.Printf("invitation %d: %v", invitation.ID, err)
+			err = nil
+		}
+	}
+
+	auditInvitation(ctx, audit.InvitationCreate, invitation.ID, map[string]interface{}{"role": invitation.Role, "email": invitation.Email})
+
+	return
+}
+
+// ListInvitations implements Service.
+func (service *UserService) ListInvitations(ctx *gin.Context) (result []Invitation, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	return service.repository.ListPendingInvitations(loginData.OrganizationID)
+}
+
+// RevokeInvitation implements Service.
+func (service *UserService) RevokeInvitation(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(ctx.Param("invitationId"))
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	revoked, err := service.repository.RevokeInvitation(loginData.OrganizationID, uint(id))
+	if err != nil {
+		return err
+	}
+
+	if !revoked {
+		return errors.New("pending invitation with given ID does not exist")
+	}
+
+	auditInvitation(ctx, audit.InvitationRevoke, uint(id), nil)
+
+	return nil
+}
+
+// AcceptInvitation implements Service. The new user chooses their own
+// username and password; organization and role come from the invitation.
+func (service *UserService) AcceptInvitation(ctx *gin.Context) (err error) {
+	var request AcceptInvitationRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateAcceptInvitation(); err != nil {
+		return
+	}
+
+	tokenHash := common.HashToken(request.Token)
+
+	invitation, err := service.repository.FindPendingInvitation(tokenHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvitationInvalid
+		}
+		return err
+	}
+
+	// the role may have been deleted since the invitation was sent
+	if !rbac.RoleExists(invitation.OrganizationID, invitation.Role) {
+		return errors.New("the role of this invitation no longer exists, please ask for a new one")
+	}
+
+	if err = service.checkPasswordPolicy(invitation.OrganizationID, request.Username, request.Password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := common.HashPassword(request.Password)
+	if err != nil {
+		return errors.New("hashing password failed")
+	}
+
+	email := invitation.Email
+	if email == "" {
+		email = request.Email
+	}
+
+	user := User{
+		OrganizationID: invitation.OrganizationID,
+		Username:       request.Username,
+		Email:          email,
+		Password:       hashedPassword,
+		Role:           invitation.Role,
+	}
+
+	if err = service.repository.AcceptInvitation(tokenHash, &user); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvitationInvalid
+		}
+
+		log.Printf("accept invitation %d: %v", invitation.ID, err)
+		return errors.New("unable to create account, the username may already be taken")
+	}
+
+	auditSelf(ctx, audit.InvitationAccept, user, map[string]interface{}{"invitation_id": invitation.ID, "role": user.Role})
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package users
+
+import (
+	"errors"
+	"gotrack/helpers/common"
+	"gotrack/middlewares"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errInvalidCredentials is returned for unknown users and wrong passwords
+	// alike, so the response does not reveal which usernames exist.
+	errInvalidCredentials = errors.New("invalid username or password")
+	errTooManyAttempts    = errors.New("too many failed login attempts, please try again later")
+)
+
+// loginThrottle describes when failures on one key start to slow logins down.
+// After Threshold failures every further failure locks the key for
+// BaseDelay * 2^(failures-Threshold), capped at MaxDelay. From LockoutAfter
+// failures on, the key is locked for Lockout.
+type loginThrottle struct {
+	Prefix       string
+	Window       time.Duration
+	Threshold    int64
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int64
+	Lockout      time.Duration
+}
+
+var (
+	usernameThrottle = loginThrottle{
+		Prefix:       "login:user:",
+		Window:       time.Hour,
+		Threshold:    3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 10,
+		Lockout:      30 * time.Minute,
+	}
+
+	ipThrottle = loginThrottle{
+		Prefix:       "login:ip:",
+		Window:       time.Hour,
+		Threshold:    20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		LockoutAfter: 100,
+		Lockout:      time.Hour,
+	}
+
+	// twoFactorThrottle counts wrong TOTP and recovery codes per user.
+	twoFactorThrottle = loginThrottle{
+		Prefix:       "2fa:user:",
+		Window:       time.Hour,
+		Threshold:    5,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     15 * time.Minute,
+		LockoutAfter: 10,
+		Lockout:      30 * time.Minute,
+	}
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is checked for unknown usernames so they cost as much
+// time as a wrong password. It is built on first use, once the hashing
+// parameters have been loaded from the environment.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = common.HashPassword("gotrack-dummy-password")
+	})
+
+	return dummyHash
+}
+
+func usernameKey(username string) string {
+	return usernameThrottle.Prefix + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return ipThrottle.Prefix + ip
+}
+
+func (t loginThrottle) delay(failures int64) time.Duration {
+	if failures >= t.LockoutAfter {
+		return t.Lockout
+	}
+
+	if failures < t.Threshold {
+		return 0
+	}
+
+	delay := t.BaseDelay
+	for i := t.Threshold; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > t.MaxDelay {
+		return t.MaxDelay
+	}
+
+	return delay
+}
+
+func (t loginThrottle) fail(key string) error {
+	failures, err := middlewares.Attempts.Fail(key, t.Window)
+	if err != nil {
+		return err
+	}
+
+	if delay := t.delay(failures); delay > 0 {
+		return middlewares.Attempts.Lock(key, time.Now().Add(delay))
+	}
+
+	return nil
+}
+
+func checkNotLocked(key string) error {
+	until, err := middlewares.Attempts.LockedUntil(key)
+	if err != nil {
+		return errors.New("unable to verify login attempts")
+	}
+
+	if time.Now().Before(until) {
+		return errTooManyAttempts
+	}
+
+	return nil
+}
+
+// checkLoginAllowed rejects logins while the username or the client IP is
+// locked. Unknown usernames are throttled the same way as existing ones.
+func checkLoginAllowed(username, ip string) error {
+	for _, key := range []string{usernameKey(username), ipKey(ip)} {
+		if err := checkNotLocked(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func recordLoginFailure(username, ip string) error {
+	if err := usernameThrottle.fail(usernameKey(username)); err != nil {
+		return err
+	}
+
+	return ipThrottle.fail(ipKey(ip))
+}
+
+// recordLoginSuccess only clears the username counter; a success must not
+// let one IP keep guessing other accounts.
+func recordLoginSuccess(username string) error {
+	return middlewares.Attempts.Reset(usernameKey(username))
+}
+
+// unlockLogin clears the lock of the username and of the addresses in ips,
+// since a user can just as well be locked out by the per-IP throttle.
+func unlockLogin(username string, ips ...string) error {
+	if err := middlewares.Attempts.Reset(usernameKey(username)); err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if ip == "" {
+			continue
+		}
+
+		if err := middlewares.Attempts.Reset(ipKey(ip)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func twoFactorKey(userID uint) string {
+	return twoFactorThrottle.Prefix + strconv.FormatUint(uint64(userID), 10)
+}
@@ -0,0 +1,27 @@
+package users
+
+import (
+	"gotrack/middlewares"
+	"testing"
+	"time"
+)
+
+func TestUnlockLoginClearsAddressLock(t *testing.T) {
+	for _, key := range []string{usernameKey("ana"), ipKey("192.0.2.7")} {
+		if err := middlewares.Attempts.Lock(key, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := checkLoginAllowed("ana", "192.0.2.7"); err != errTooManyAttempts {
+		t.Fatalf("err = %v before the unlock, want %v", err, errTooManyAttempts)
+	}
+
+	if err := unlockLogin("ana", "", "192.0.2.7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkLoginAllowed("ana", "192.0.2.7"); err != nil {
+		t.Errorf("still locked after the unlock: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/geoip"
+	"gotrack/helpers/notifier"
+	"gotrack/middlewares"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Anomalies a login can be flagged with.
+const (
+	anomalyNewCountry       = "new_country"
+	anomalyImpossibleTravel = "impossible_travel"
+	anomalyNewDevice        = "new_device"
+)
+
+var (
+	// MaxTravelSpeedKmh is the fastest a user is believed to move between two
+	// logins, roughly an airliner.
+	MaxTravelSpeedKmh = 900.0
+	// minTravelDistanceKm ignores short hops, since IP locations are only
+	// accurate to a city or region.
+	minTravelDistanceKm = 300.0
+)
+
+// LoginAnomalyHook is called for every flagged login with the owners of the
+// organization. The default mails the owners that have an email; replace it
+// to forward alerts elsewhere.
+var LoginAnomalyHook = mailOwners
+
+func mailOwners(login LoginHistory, owners []User) error {
+	for _, owner := range owners {
+		if owner.Email == "" {
+			continue
+		}
+
+		err := notifier.Send(notifier.Message{
+			To:      owner.Email,
+			Subject: fmt.Sprintf("Unusual login by %s", login.Username),
+			Body: fmt.Sprintf("Hi %s,\n\n%s logged in at %s from %s (%s) using %q.\n\nThis was flagged as: %s.\n\n"+
+				"If this was not them, revoke their sessions and reset their password.",
+				owner.Username, login.Username, login.CreatedAt.Format(time.RFC1123), login.IP,
+				describeLocation(login), login.UserAgent, strings.Join(login.Anomalies, ", ")),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func describeLocation(login LoginHistory) string {
+	parts := []string{}
+	for _, part := range []string{login.City, login.Region, login.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "unknown location"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// detectAnomalies compares a login to the history of the user. The very first
+// login only sets the baseline.
+func detectAnomalies(login LoginHistory, previous *LoginHistory, countrySeen, deviceSeen bool) []string {
+	anomalies := []string{}
+	if previous == nil {
+		return anomalies
+	}
+
+	if login.Country != "" && !countrySeen {
+		anomalies = append(anomalies, anomalyNewCountry)
+	}
+
+	if login.Latitude != nil && previous.Latitude != nil {
+		distance := geoip.DistanceKm(
+			geoip.Location{Latitude: *previous.Latitude, Longitude: *previous.Longitude},
+			geoip.Location{Latitude: *login.Latitude, Longitude: *login.Longitude},
+		)
+		hours := login.CreatedAt.Sub(previous.CreatedAt).Hours()
+
+		if distance >= minTravelDistanceKm && (hours <= 0 || distance/hours > MaxTravelSpeedKmh) {
+			anomalies = append(anomalies, anomalyImpossibleTravel)
+		}
+	}
+
+	if login.UserAgent != "" && !deviceSeen {
+		anomalies = append(anomalies, anomalyNewDevice)
+	}
+
+	return anomalies
+}
+
+// recordLogin stores a successful login and raises its anomalies. It runs
+// after the response, since the IP lookup is slow; failures are only logged.
+func (service *UserService) recordLogin(entry audit.Log, user User, method string) {
+	login := LoginHistory{
+		CreatedAt:      time.Now(),
+		OrganizationID: user.OrganizationID,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Method:         method,
+		IP:             entry.IP,
+		UserAgent:      entry.UserAgent,
+	}
+
+	location, err := geoip.Locate(login.IP)
+	if err != nil {
+		log.Printf("login history: locate %s: %v", login.IP, err)
+	}
+
+	login.Country = location.Country
+	login.Region = location.Region
+	login.City = location.City
+	if location.HasCoordinates {
+		login.Latitude = &location.Latitude
+		login.Longitude = &location.Longitude
+	}
+
+	var previous *LoginHistory
+	last, err := service.repository.LastLogin(user.ID)
+	if err == nil {
+		previous = &last
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("login history of user %d: %v", user.ID, err)
+		return
+	}
+
+	countrySeen, err := service.repository.HasLoginFromCountry(user.ID, login.Country)
+	if err != nil {
+		log.Printf("login history of user %d: %v", user.ID, err)
+		return
+	}
+
+	deviceSeen, err := service.repository.HasLoginFromDevice(user.ID, login.UserAgent)
+	if err != nil {
+		log.Printf("login history of user %d: %v", user.ID, err)
+		return
+	}
+
+	login.Anomalies = detectAnomalies(login, previous, countrySeen, deviceSeen)
+	login.Flagged = len(login.Anomalies) > 0
+
+	if err = service.repository.CreateLoginHistory(&login); err != nil {
+		log.Printf("login history of user %d: %v", user.ID, err)
+		return
+	}
+
+	if !login.Flagged {
+		return
+	}
+
+	entry.Action = audit.LoginAnomaly
+	entry.TargetType = "login"
+	entry.TargetID = strconv.FormatUint(uint64(login.ID), 10)
+	audit.Record(entry, map[string]interface{}{"anomalies": login.Anomalies, "country": login.Country})
+
+	owners, err := service.repository.FindOwners(user.OrganizationID)
+	if err != nil {
+		log.Printf("login anomaly %d: find owners: %v", login.ID, err)
+		return
+	}
+
+	if err = LoginAnomalyHook(login, owners); err != nil {
+		log.Printf("login anomaly %d: notify owners: %v", login.ID, err)
+	}
+}
+
+// loginEntry captures the request details recordLogin needs, since the gin
+// context must not be used once the request is done.
+func loginEntry(ctx *gin.Context, user User) audit.Log {
+	entry := middlewares.AuditEntry(ctx, audit.LoginSuccess)
+	entry.OrganizationID = user.OrganizationID
+	entry.ActorID = user.ID
+	entry.ActorName = user.Username
+	entry.UserAgent = userAgent(ctx)
+
+	return entry
+}
+
+func pageParams(ctx *gin.Context) (page int, limit int) {
+	page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ = strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	return
+}
+
+// ListLoginHistory implements Service.
+func (service *UserService) ListLoginHistory(ctx *gin.Context) (result []LoginHistory, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	user, err := service.userFromParam(ctx, loginData.OrganizationID)
+	if err != nil {
+		return
+	}
+
+	page, limit := pageParams(ctx)
+
+	return service.repository.ListLoginHistory(loginData.OrganizationID, user.ID, page, limit)
+}
+
+// ListLoginAnomalies implements Service.
+func (service *UserService) ListLoginAnomalies(ctx *gin.Context) (result []LoginHistory, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	page, limit := pageParams(ctx)
+
+	return service.repository.ListLoginAnomalies(loginData.OrganizationID, page, limit)
+}
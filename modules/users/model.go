@@ -3,9 +3,12 @@ package users
 import (
 	"errors"
 	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"gotrack/modules/organizations"
 	"net"
+	"net/mail"
 	"os"
-	"regexp"
+	"time"
 
 	"github.com/ipinfo/go/v2/ipinfo"
 	"gorm.io/gorm"
@@ -13,10 +16,17 @@ import (
 
 type User struct {
 	gorm.Model
-	Username string `json:"username" gorm:"unique"`
-	Password string `json:"password"`
-	Role     string `json:"role" gorm:"type:varchar(20)"` // "owner" or "employee"
-	IP       string `json:"ip"`
+	OrganizationID uint   `json:"organization_id" gorm:"index"`
+	Username       string `json:"username" gorm:"unique"`
+	Email          string `json:"email" gorm:"index"`
+	Password       string `json:"password"`
+	Role           string `json:"role" gorm:"type:varchar(20)"` // name of a rbac.Role, e.g. "owner" or "employee"
+	IP             string `json:"ip"`
+	TOTPSecret     string `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled    bool   `json:"totp_enabled" gorm:"column:totp_enabled"`
+	TOTPLastStep   int64  `json:"-" gorm:"column:totp_last_step"`
+	// OIDCSubject links the user to an identity provider account as "issuer|sub"
+	OIDCSubject string `json:"-" gorm:"column:oidc_subject;uniqueIndex:idx_users_oidc_subject,where:oidc_subject <> ''"`
 }
 
 func (User) TableName() string {
@@ -25,16 +35,17 @@ func (User) TableName() string {
 
 type IPInfo struct {
 	gorm.Model
-	IP       string `json:"ip"`
-	Hostname string `json:"hostname"`
-	City     string `json:"city"`
-	Region   string `json:"region"`
-	Country  string `json:"country"`
-	Loc      string `json:"loc"` // Format: "latitude,longitude"
-	Org      string `json:"org"`
-	Postal   string `json:"postal"`
-	Timezone string `json:"timezone"`
-	UserID   uint   `json:"user_id"`
+	OrganizationID uint   `json:"organization_id" gorm:"index"`
+	IP             string `json:"ip"`
+	Hostname       string `json:"hostname"`
+	City           string `json:"city"`
+	Region         string `json:"region"`
+	Country        string `json:"country"`
+	Loc            string `json:"loc"` // Format: "latitude,longitude"
+	Org            string `json:"org"`
+	Postal         string `json:"postal"`
+	Timezone       string `json:"timezone"`
+	UserID         uint   `json:"user_id"`
 }
 
 func (IPInfo) TableName() string {
@@ -56,10 +67,11 @@ type IPInfoResponse struct {
 
 type DetailLocation struct {
 	gorm.Model
-	IpID     int    `json:"ip_id" gorm:"column:ip_id"`
-	OrderID  int    `json:"order_id" gorm:"column:order_id"`
-	Pict     string `json:"bukti_pengiriman"`
-	Location IPInfo `gorm:"foreignKey:IpID; references:ID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	OrganizationID uint   `json:"organization_id" gorm:"index"`
+	IpID           int    `json:"ip_id" gorm:"column:ip_id"`
+	OrderID        int    `json:"order_id" gorm:"column:order_id"`
+	Pict           string `json:"bukti_pengiriman"`
+	Location       IPInfo `gorm:"foreignKey:IpID; references:ID; constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
 func (DetailLocation) TableName() string {
@@ -84,11 +96,50 @@ func (l *LoginRequest) ValidateLogin() (err error) {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string    `json:"token,omitempty"`
+	ExpiredAt    time.Time `json:"expired_at,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+
+	// set instead of the tokens when a second factor is needed
+	TwoFactorRequired  bool   `json:"two_factor_required,omitempty"`
+	EnrollmentRequired bool   `json:"enrollment_required,omitempty"`
+	ChallengeToken     string `json:"challenge_token,omitempty"`
+}
+
+// RefreshToken is stored hashed. Every rotation creates a new row in the same
+// family, so reusing an already rotated token can revoke the whole chain.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	FamilyID  string     `json:"family_id" gorm:"type:varchar(64);index"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	UserAgent string     `json:"user_agent"`
+	ClientIP  string     `json:"client_ip"`
+	LoginAt   time.Time  `json:"login_at"`
+	ExpiredAt time.Time  `json:"expired_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *RefreshRequest) ValidateRefresh() (err error) {
+	if common.IsEmptyField(r.RefreshToken) {
+		return errors.New("refresh token required")
+	}
+
+	return
 }
 
 type SignUpRequest struct {
 	Username       string `json:"username"`
+	Email          string `json:"email"`
 	Password       string `json:"password"`
 	ReTypePassword string `json:"re_type_password"`
 	Role           string `json:"role"`
@@ -99,25 +150,44 @@ func (s *SignUpRequest) ValidateSignUp() (err error) {
 		return errors.New("username required")
 	}
 
-	if common.IsEmptyField(s.Password) {
+	if err = validateEmail(s.Email); err != nil {
+		return err
+	}
+
+	if common.IsEmptyField(s.Role) {
+		return errors.New("role required")
+	}
+
+	return validateNewPassword(s.Password, s.ReTypePassword)
+}
+
+// validateNewPassword checks a password that is being set together with its
+// confirmation. The strength rules live in the organization password policy.
+func validateNewPassword(password, reTypePassword string) error {
+	if common.IsEmptyField(password) {
 		return errors.New("password required")
 	}
 
-	if common.IsEmptyField(s.ReTypePassword) {
+	if common.IsEmptyField(reTypePassword) {
 		return errors.New("retype password required")
 	}
 
-	if common.IsEmptyField(s.Role) {
-		return errors.New("role required")
+	if reTypePassword != password {
+		return errors.New("password mismatch")
 	}
 
-	if s.ReTypePassword != s.Password {
-		return errors.New("password mismatch")
+	return nil
+}
+
+// validateEmail accepts an empty address, since email is optional.
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
 	}
 
-	re := regexp.MustCompile(`^(.{8,})$`)
-	if !re.MatchString(s.Password) {
-		return errors.New("please make sure that the password contains at least 8 characters")
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("invalid email")
 	}
 
 	return nil
@@ -132,6 +202,7 @@ func (s *SignUpRequest) ConvertToModelForSignUp() (user User, err error) {
 
 	return User{
 		Username: s.Username,
+		Email:    s.Email,
 		Password: hashedPassword,
 		Role:     s.Role,
 	}, nil
@@ -182,6 +253,7 @@ func GetGeoLocation(ip string) (interface{}, error) {
 
 type UpdatePayload struct {
 	Username string `json:"username"`
+	Email    string `json:"email"`
 	Password string `json:"password"`
 	Role     string `json:"role"`
 }
@@ -199,3 +271,301 @@ func (s *UpdatePayload) ConvertToModelForUpdatePayload() (user User, err error)
 		Role:     s.Role,
 	}, nil
 }
+
+type RegisterOrganizationRequest struct {
+	OrganizationName string `json:"organization_name"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	Password         string `json:"password"`
+	ReTypePassword   string `json:"re_type_password"`
+}
+
+func (r *RegisterOrganizationRequest) ValidateRegister() (err error) {
+	if common.IsEmptyField(r.OrganizationName) {
+		return errors.New("organization name required")
+	}
+
+	signUp := SignUpRequest{
+		Username:       r.Username,
+		Email:          r.Email,
+		Password:       r.Password,
+		ReTypePassword: r.ReTypePassword,
+		Role:           rbac.OwnerRole,
+	}
+
+	return signUp.ValidateSignUp()
+}
+
+// ConvertToModel returns the new organization and its first owner.
+func (r *RegisterOrganizationRequest) ConvertToModel() (organization organizations.Organization, user User, err error) {
+	hashedPassword, err := common.HashPassword(r.Password)
+	if err != nil {
+		err = errors.New("hashing password failed")
+		return
+	}
+
+	organization = organizations.Organization{
+		Name: r.OrganizationName,
+	}
+
+	user = User{
+		Username: r.Username,
+		Email:    r.Email,
+		Password: hashedPassword,
+		Role:     rbac.OwnerRole,
+	}
+
+	return
+}
+
+// RecoveryCode is a hashed single-use code that replaces a TOTP code when the
+// authenticator device is lost.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `json:"user_id" gorm:"index"`
+	CodeHash string     `json:"-" gorm:"type:varchar(64)"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+type ChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+}
+
+func (c *ChallengeRequest) ValidateChallenge() (err error) {
+	if common.IsEmptyField(c.ChallengeToken) {
+		return errors.New("challenge token required")
+	}
+
+	return
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+func (t *TwoFactorLoginRequest) ValidateTwoFactorLogin() (err error) {
+	if common.IsEmptyField(t.ChallengeToken) {
+		return errors.New("challenge token required")
+	}
+
+	if common.IsEmptyField(t.Code) && common.IsEmptyField(t.RecoveryCode) {
+		return errors.New("code or recovery code required")
+	}
+
+	return
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (t *TwoFactorCodeRequest) ValidateCode() (err error) {
+	if common.IsEmptyField(t.Code) {
+		return errors.New("code required")
+	}
+
+	return
+}
+
+type TwoFactorEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// PasswordReset is a hashed single-use token sent to the user to set a new
+// password.
+type PasswordReset struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	ExpiredAt time.Time  `json:"expired_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+type ForgotPasswordRequest struct {
+	Username string `json:"username"`
+}
+
+func (f *ForgotPasswordRequest) ValidateForgotPassword() (err error) {
+	if common.IsEmptyField(f.Username) {
+		return errors.New("username required")
+	}
+
+	return
+}
+
+type ResetPasswordRequest struct {
+	Token          string `json:"token"`
+	Password       string `json:"password"`
+	ReTypePassword string `json:"re_type_password"`
+}
+
+func (r *ResetPasswordRequest) ValidateResetPassword() (err error) {
+	if common.IsEmptyField(r.Token) {
+		return errors.New("token required")
+	}
+
+	return validateNewPassword(r.Password, r.ReTypePassword)
+}
+
+// LoginHistory is one successful login. Anomalies lists what was unusual
+// about it compared to the earlier logins of the user, see anomaly*.
+type LoginHistory struct {
+	ID             uint      `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+	OrganizationID uint      `json:"organization_id" gorm:"index"`
+	UserID         uint      `json:"user_id" gorm:"index"`
+	Username       string    `json:"username"`
+	Method         string    `json:"method" gorm:"type:varchar(20)"`
+	IP             string    `json:"ip"`
+	Country        string    `json:"country" gorm:"type:varchar(2)"`
+	Region         string    `json:"region"`
+	City           string    `json:"city"`
+	Latitude       *float64  `json:"latitude"`
+	Longitude      *float64  `json:"longitude"`
+	UserAgent      string    `json:"user_agent"`
+	Anomalies      []string  `json:"anomalies" gorm:"serializer:json"`
+	Flagged        bool      `json:"flagged" gorm:"index"`
+}
+
+func (LoginHistory) TableName() string {
+	return "login_history"
+}
+
+// UnlockRequest names an address the user is locked out from when it is not
+// the one they last logged in from, e.g. the audit log of the lockout.
+type UnlockRequest struct {
+	IP string `json:"ip"`
+}
+
+type ImpersonationRequest struct {
+	Minutes int `json:"minutes"` // defaults to 15, at most 60
+}
+
+// ImpersonationResponse is an access token acting as another user. Requests
+// made with it carry the X-Impersonator-ID response header.
+type ImpersonationResponse struct {
+	Token          string    `json:"token"`
+	ExpiredAt      time.Time `json:"expired_at"`
+	UserID         uint      `json:"user_id"`
+	Username       string    `json:"username"`
+	Role           string    `json:"role"`
+	ImpersonatorID uint      `json:"impersonator_id"`
+}
+
+// Invitation lets someone create their own account in an organization with
+// a role picked by the inviter. Only a hash of the single-use token is kept.
+type Invitation struct {
+	gorm.Model
+	OrganizationID uint       `json:"organization_id" gorm:"index"`
+	InvitedBy      uint       `json:"invited_by"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	TokenHash      string     `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	ExpiredAt      time.Time  `json:"expired_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	AcceptedBy     *uint      `json:"accepted_by"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+func (Invitation) TableName() string {
+	return "invitations"
+}
+
+type InvitationRequest struct {
+	Email     string     `json:"email"` // optional, the invitation is mailed when set
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"` // defaults to 7 days, at most 30 days
+}
+
+func (i *InvitationRequest) ValidateInvitation() (err error) {
+	if common.IsEmptyField(i.Role) {
+		return errors.New("role required")
+	}
+
+	if err = validateEmail(i.Email); err != nil {
+		return err
+	}
+
+	if i.ExpiresAt != nil {
+		if !i.ExpiresAt.After(time.Now()) {
+			return errors.New("expires_at must be in the future")
+		}
+
+		if i.ExpiresAt.After(time.Now().Add(MaxInvitationTTL)) {
+			return errors.New("expires_at must be within 30 days")
+		}
+	}
+
+	return
+}
+
+// CreatedInvitation is only returned when the invitation is created; the
+// token cannot be shown again.
+type CreatedInvitation struct {
+	Invitation
+	Token string `json:"token"`
+	Link  string `json:"link"`
+}
+
+type AcceptInvitationRequest struct {
+	Token          string `json:"token"`
+	Username       string `json:"username"`
+	Email          string `json:"email"` // ignored when the invitation was sent to an email
+	Password       string `json:"password"`
+	ReTypePassword string `json:"re_type_password"`
+}
+
+func (a *AcceptInvitationRequest) ValidateAcceptInvitation() (err error) {
+	if common.IsEmptyField(a.Token) {
+		return errors.New("token required")
+	}
+
+	if common.IsEmptyField(a.Username) {
+		return errors.New("username required")
+	}
+
+	if err = validateEmail(a.Email); err != nil {
+		return err
+	}
+
+	return validateNewPassword(a.Password, a.ReTypePassword)
+}
+
+// Session is one login of a user as shown to them and to owners. It spans
+// the refresh token family, so it outlives the short access tokens.
+type Session struct {
+	ID           string    `json:"id"`
+	UserID       uint      `json:"user_id"`
+	ClientIP     string    `json:"client_ip"`
+	UserAgent    string    `json:"user_agent"`
+	LoginAt      time.Time `json:"login_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiredAt    time.Time `json:"expired_at"`
+	Current      bool      `json:"current"`
+}
+
+// ConvertToSession builds the session view of the active token of a family.
+func (r *RefreshToken) ConvertToSession(currentFamily string) Session {
+	return Session{
+		ID:           r.FamilyID,
+		UserID:       r.UserID,
+		ClientIP:     r.ClientIP,
+		UserAgent:    r.UserAgent,
+		LoginAt:      r.LoginAt,
+		LastActiveAt: r.CreatedAt,
+		ExpiredAt:    r.ExpiredAt,
+		Current:      r.FamilyID == currentFamily,
+	}
+}
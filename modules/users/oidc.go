@@ -0,0 +1,257 @@
+package users
+
+import (
+	"crypto/subtle"
+	"errors"
+	"gotrack/helpers/common"
+	"gotrack/helpers/oidc"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcPurpose = "oidc"
+	oidcCookie  = "gotrack_oidc"
+	oidcPath    = "/api/users/oidc"
+
+	// oidcLoginTTL is how long the user may take at the identity provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+var errOIDCFailed = errors.New("single sign-on failed, please try again")
+
+func oidcProvider() (*oidc.Provider, error) {
+	if oidc.Default == nil {
+		return nil, errors.New("single sign-on is not configured")
+	}
+
+	return oidc.Default, nil
+}
+
+// oidcOrganization is the tenant SSO users are matched in and provisioned to.
+func oidcOrganization() (uint, error) {
+	id, err := strconv.ParseUint(os.Getenv("OIDC_ORGANIZATION_ID"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("single sign-on organization is not configured")
+	}
+
+	return uint(id), nil
+}
+
+func isSecureRequest(ctx *gin.Context) bool {
+	return ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// OIDCLogin implements Service. The PKCE verifier, state and nonce travel in
+// a signed, HttpOnly cookie, so no server side state is needed between the
+// redirect and the callback.
+func (service *UserService) OIDCLogin(ctx *gin.Context) (authURL string, err error) {
+	provider, err := oidcProvider()
+	if err != nil {
+		return
+	}
+
+	state, err := common.GenerateRandomToken(24)
+	if err != nil {
+		return
+	}
+
+	nonce, err := common.GenerateRandomToken(24)
+	if err != nil {
+		return
+	}
+
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		return
+	}
+
+	authURL, err = provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		err = errors.New("identity provider is not reachable")
+		return
+	}
+
+	token, _, err := middlewares.GenerateChallengeToken(0, oidcPurpose, oidcLoginTTL, map[string]string{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcCookie, token, int(oidcLoginTTL.Seconds()), oidcPath, "", isSecureRequest(ctx), true)
+
+	return
+}
+
+// OIDCCallback implements Service.
+func (service *UserService) OIDCCallback(ctx *gin.Context) (result LoginResponse, err error) {
+	provider, err := oidcProvider()
+	if err != nil {
+		return
+	}
+
+	if idpError := ctx.Query("error"); idpError != "" {
+		err = errors.New("single sign-on failed: " + idpError)
+		return
+	}
+
+	cookie, err := ctx.Cookie(oidcCookie)
+	if err != nil {
+		err = errors.New("single sign-on expired, please try again")
+		return
+	}
+
+	// the cookie is single use either way
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcCookie, "", -1, oidcPath, "", isSecureRequest(ctx), true)
+
+	_, claims, err := middlewares.ParseChallengeToken(cookie, oidcPurpose)
+	if err != nil {
+		err = errors.New("single sign-on expired, please try again")
+		return
+	}
+
+	state := ctx.Query("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(claims.Values["state"])) != 1 {
+		err = errOIDCFailed
+		return
+	}
+
+	code := ctx.Query("code")
+	if code == "" {
+		err = errOIDCFailed
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(code, claims.Values["verifier"])
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		err = errOIDCFailed
+		return
+	}
+
+	identity, err := provider.VerifyIDToken(rawIDToken, claims.Values["nonce"])
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		err = errOIDCFailed
+		return
+	}
+
+	user, err := service.resolveOIDCUser(provider, identity)
+	if err != nil {
+		return
+	}
+
+	required, err := service.twoFactorRequired(user)
+	if err != nil {
+		return
+	}
+
+	if required {
+		return service.twoFactorChallenge(user)
+	}
+
+	return service.completeLogin(ctx, user, "oidc")
+}
+
+// resolveOIDCUser maps an identity to a GoTrack user: by the linked subject
+// first, then by verified email within the SSO organization, and finally by
+// provisioning a new user when OIDC_AUTO_PROVISION is enabled.
+func (service *UserService) resolveOIDCUser(provider *oidc.Provider, identity oidc.Claims) (user User, err error) {
+	subject := provider.Issuer + "|" + identity.Subject
+
+	user, err = service.repository.FindByOIDCSubject(subject)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	orgID, err := oidcOrganization()
+	if err != nil {
+		return
+	}
+
+	if identity.Email != "" && identity.EmailVerified {
+		user, err = service.repository.FindUnlinkedByEmail(orgID, identity.Email)
+		if err == nil {
+			if err = service.repository.LinkOIDCSubject(user.ID, subject); err != nil {
+				return
+			}
+
+			user.OIDCSubject = subject
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+	}
+
+	if os.Getenv("OIDC_AUTO_PROVISION") != "true" {
+		err = errors.New("no account is linked to this identity, please ask an owner")
+		return
+	}
+
+	role := os.Getenv("OIDC_DEFAULT_ROLE")
+	if role == "" {
+		role = "employee"
+	}
+
+	if !rbac.RoleExists(orgID, role) {
+		err = errors.New("single sign-on default role does not exist")
+		return
+	}
+
+	username := oidcUsername(identity)
+	if username == "" {
+		err = errors.New("identity provider did not send a username")
+		return
+	}
+
+	user = User{
+		OrganizationID: orgID,
+		Username:       username,
+		Role:           role,
+		OIDCSubject:    subject,
+	}
+	if identity.EmailVerified {
+		user.Email = identity.Email
+	}
+
+	if err = service.repository.CreateUser(&user); err != nil {
+		log.Printf("oidc: provision user %q: %v", username, err)
+		err = errors.New("unable to create account, the username may already be taken")
+		return
+	}
+
+	return
+}
+
+// oidcUsername reads OIDC_USERNAME_CLAIM (preferred_username by default),
+// falling back to the email.
+func oidcUsername(identity oidc.Claims) string {
+	claim := os.Getenv("OIDC_USERNAME_CLAIM")
+	if claim == "" {
+		claim = "preferred_username"
+	}
+
+	if username, _ := identity.Extra[claim].(string); username != "" {
+		return username
+	}
+
+	return identity.Email
+}
@@ -0,0 +1,319 @@
+package users
+
+import (
+	"errors"
+	"gotrack/helpers/geoip"
+	"gotrack/helpers/oidc"
+	"gotrack/helpers/oidc/oidctest"
+	"gotrack/helpers/rbac"
+	"gotrack/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// oidcRepository keeps the users of the OIDC tests in memory. Methods the
+// flow does not need panic through the embedded nil Repository.
+type oidcRepository struct {
+	Repository
+
+	mu    sync.Mutex
+	users []User
+}
+
+func (r *oidcRepository) FindByOIDCSubject(subject string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.OIDCSubject == subject {
+			return user, nil
+		}
+	}
+
+	return User{}, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) FindUnlinkedByEmail(orgID uint, email string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.OrganizationID == orgID && user.Email == email && user.OIDCSubject == "" {
+			return user, nil
+		}
+	}
+
+	return User{}, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) LinkOIDCSubject(userID uint, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID {
+			r.users[i].OIDCSubject = subject
+			return nil
+		}
+	}
+
+	return gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) CreateUser(user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Username == user.Username {
+			return errors.New("duplicate username")
+		}
+	}
+
+	user.ID = uint(len(r.users) + 1)
+	r.users = append(r.users, *user)
+	return nil
+}
+
+func (r *oidcRepository) CreateRefreshToken(token RefreshToken) error { return nil }
+
+func (r *oidcRepository) UpdateIPEmployee(userID uint, ipAddress string) error { return nil }
+
+func (r *oidcRepository) LastLogin(userID uint) (LoginHistory, error) {
+	return LoginHistory{}, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) HasLoginFromCountry(userID uint, country string) (bool, error) {
+	return true, nil
+}
+
+func (r *oidcRepository) HasLoginFromDevice(userID uint, userAgent string) (bool, error) {
+	return true, nil
+}
+
+func (r *oidcRepository) CreateLoginHistory(login *LoginHistory) error { return nil }
+
+func (r *oidcRepository) FindOwners(orgID uint) ([]User, error) { return nil, nil }
+
+type noLocation struct{}
+
+func (noLocation) Locate(ip string) (geoip.Location, error) { return geoip.Location{}, nil }
+
+// the login history is written in the background after the test returns,
+// so the locator is replaced once and for all
+func init() {
+	geoip.Default = noLocation{}
+}
+
+const oidcTestClient = "gotrack"
+
+// setupOIDC points the package at a mock provider and an in-memory
+// repository of organization 1.
+func setupOIDC(t *testing.T, autoProvision bool) (*oidctest.Server, *oidcRepository) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("jwt_secret_key", "test-secret")
+	t.Setenv("OIDC_ORGANIZATION_ID", "1")
+	t.Setenv("OIDC_DEFAULT_ROLE", "employee")
+	if autoProvision {
+		t.Setenv("OIDC_AUTO_PROVISION", "true")
+	} else {
+		t.Setenv("OIDC_AUTO_PROVISION", "false")
+	}
+
+	if err := middlewares.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	rbac.Load([]rbac.Role{{OrganizationID: 1, Name: "employee"}})
+
+	server := oidctest.NewServer(t, oidcTestClient)
+	oidc.Default = oidc.NewProvider(server.URL, oidcTestClient, "", "http://localhost"+oidcPath+"/callback", []string{"openid", "email"})
+	t.Cleanup(func() { oidc.Default = nil })
+
+	return server, &oidcRepository{}
+}
+
+// oidcLogin runs OIDCLogin and the user's visit at the provider, and returns
+// the callback request the browser makes afterwards.
+func oidcLogin(t *testing.T, service *UserService, server *oidctest.Server) *http.Request {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, oidcPath+"/login", nil)
+
+	authURL, err := service.OIDCLogin(ctx)
+	if err != nil {
+		t.Fatalf("OIDCLogin: %v", err)
+	}
+
+	callback, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, cookie := range recorder.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+
+	return request
+}
+
+func oidcCallback(service *UserService, request *http.Request) (LoginResponse, error) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = request
+
+	return service.OIDCCallback(ctx)
+}
+
+func TestOIDCProvisionsUser(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+
+	server.SetIdentity(map[string]interface{}{
+		"sub":                "idp-user-1",
+		"email":              "ana@example.com",
+		"email_verified":     true,
+		"preferred_username": "ana",
+	})
+
+	result, err := oidcCallback(service, oidcLogin(t, service, server))
+	if err != nil {
+		t.Fatalf("OIDCCallback: %v", err)
+	}
+	if result.Token == "" || result.RefreshToken == "" {
+		t.Errorf("no tokens issued: %+v", result)
+	}
+
+	if len(repository.users) != 1 {
+		t.Fatalf("%d users provisioned, want 1", len(repository.users))
+	}
+
+	user := repository.users[0]
+	if user.Username != "ana" || user.Email != "ana@example.com" || user.Role != "employee" ||
+		user.OrganizationID != 1 || user.OIDCSubject != server.URL+"|idp-user-1" {
+		t.Errorf("provisioned user = %+v", user)
+	}
+
+	// the second login finds the linked user instead of creating one
+	if _, err = oidcCallback(service, oidcLogin(t, service, server)); err != nil {
+		t.Fatalf("second OIDCCallback: %v", err)
+	}
+	if len(repository.users) != 1 {
+		t.Errorf("%d users after the second login, want 1", len(repository.users))
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	server, repository := setupOIDC(t, false)
+	repository.users = []User{{Model: gorm.Model{ID: 1}, OrganizationID: 1, Username: "ana", Email: "ana@example.com", Role: "employee"}}
+	service := &UserService{repository: repository}
+
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "email": "ana@example.com", "email_verified": true})
+
+	if _, err := oidcCallback(service, oidcLogin(t, service, server)); err != nil {
+		t.Fatalf("OIDCCallback: %v", err)
+	}
+
+	if repository.users[0].OIDCSubject != server.URL+"|idp-user-1" {
+		t.Errorf("user not linked: %+v", repository.users[0])
+	}
+}
+
+func TestOIDCRefusesUnknownUserWithoutProvisioning(t *testing.T) {
+	server, repository := setupOIDC(t, false)
+	service := &UserService{repository: repository}
+
+	// an unverified email must not be matched to an account
+	repository.users = []User{{Model: gorm.Model{ID: 1}, OrganizationID: 1, Username: "ana", Email: "ana@example.com"}}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-2", "email": "ana@example.com", "email_verified": false})
+
+	if _, err := oidcCallback(service, oidcLogin(t, service, server)); err == nil {
+		t.Error("unknown identity logged in")
+	}
+	if repository.users[0].OIDCSubject != "" {
+		t.Error("unverified email linked")
+	}
+}
+
+func TestOIDCRejectsStateMismatch(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "preferred_username": "ana"})
+
+	request := oidcLogin(t, service, server)
+	query := request.URL.Query()
+	query.Set("state", "forged")
+	request.URL.RawQuery = query.Encode()
+
+	if _, err := oidcCallback(service, request); !errors.Is(err, errOIDCFailed) {
+		t.Errorf("err = %v, want %v", err, errOIDCFailed)
+	}
+	if len(repository.users) != 0 {
+		t.Error("user provisioned despite the forged state")
+	}
+}
+
+func TestOIDCRequiresLoginCookie(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "preferred_username": "ana"})
+
+	request := oidcLogin(t, service, server)
+	request.Header.Del("Cookie")
+
+	if _, err := oidcCallback(service, request); err == nil {
+		t.Error("callback accepted without the PKCE cookie")
+	}
+}
+
+func TestOIDCRejectsNonceMismatch(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "preferred_username": "ana"})
+	server.SetNonce("replayed")
+
+	if _, err := oidcCallback(service, oidcLogin(t, service, server)); !errors.Is(err, errOIDCFailed) {
+		t.Errorf("err = %v, want %v", err, errOIDCFailed)
+	}
+}
+
+func TestOIDCRejectsOtherAudience(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "preferred_username": "ana"})
+	server.SetAudience("another-client")
+
+	if _, err := oidcCallback(service, oidcLogin(t, service, server)); !errors.Is(err, errOIDCFailed) {
+		t.Errorf("err = %v, want %v", err, errOIDCFailed)
+	}
+	if len(repository.users) != 0 {
+		t.Error("user provisioned from a token for another client")
+	}
+}
+
+func TestOIDCCallbackCodeIsSingleUse(t *testing.T) {
+	server, repository := setupOIDC(t, true)
+	service := &UserService{repository: repository}
+	server.SetIdentity(map[string]interface{}{"sub": "idp-user-1", "preferred_username": "ana"})
+
+	request := oidcLogin(t, service, server)
+	replay := request.Clone(request.Context())
+	replay.URL, _ = url.Parse(request.URL.String())
+
+	if _, err := oidcCallback(service, request); err != nil {
+		t.Fatalf("OIDCCallback: %v", err)
+	}
+	if _, err := oidcCallback(service, replay); err == nil {
+		t.Error("callback replayed")
+	}
+}
@@ -0,0 +1,181 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/common"
+	"gotrack/helpers/notifier"
+	"gotrack/middlewares"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PasswordResetTTL is how long a reset link stays valid.
+var PasswordResetTTL = 30 * time.Minute
+
+// resetThrottle limits how many reset mails one account can trigger.
+var resetThrottle = loginThrottle{
+	Prefix:       "reset:user:",
+	Window:       time.Hour,
+	Threshold:    3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	LockoutAfter: 10,
+	Lockout:      time.Hour,
+}
+
+func resetLink(token string) string {
+	return tokenLink(os.Getenv("PASSWORD_RESET_URL"), token)
+}
+
+// tokenLink appends token to a front end URL, or returns the bare token when
+// no URL is configured.
+func tokenLink(base, token string) string {
+	if base == "" {
+		return token
+	}
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+
+	return base + separator + "token=" + url.QueryEscape(token)
+}
+
+// ForgotPassword implements Service. It answers the same way and in about the
+// same time whether or not the account exists: the throttle applies to any
+// username, and the token, mail and audit entry are handled in the
+// background. Delivery problems are only logged.
+func (service *UserService) ForgotPassword(ctx *gin.Context) (err error) {
+	var request ForgotPasswordRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateForgotPassword(); err != nil {
+		return
+	}
+
+	key := resetThrottle.Prefix + strings.ToLower(request.Username)
+	if checkNotLocked(key) != nil {
+		return nil
+	}
+
+	if err = resetThrottle.fail(key); err != nil {
+		return errors.New("unable to record password reset request")
+	}
+
+	user, err := service.repository.Login(LoginRequest{Username: request.Username})
+	if err != nil {
+		return
+	}
+
+	if common.IsEmptyField(user.ID) || user.Email == "" {
+		return nil
+	}
+
+	go service.requestPasswordReset(ctx.Copy(), user)
+
+	return nil
+}
+
+// requestPasswordReset mails the reset link and audits the request. It runs
+// off the request path, see ForgotPassword.
+func (service *UserService) requestPasswordReset(ctx *gin.Context, user User) {
+	if err := service.sendPasswordReset(user); err != nil {
+		log.Printf("password reset for user %d: %v", user.ID, err)
+	}
+
+	auditUser(ctx, audit.PasswordResetRequest, user, nil)
+}
+
+func (service *UserService) sendPasswordReset(user User) error {
+	token, err := common.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = service.repository.CreatePasswordReset(PasswordReset{
+		UserID:    user.ID,
+		TokenHash: common.HashToken(token),
+		ExpiredAt: time.Now().Add(PasswordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return notifier.Send(notifier.Message{
+		To:      user.Email,
+		Subject: "Reset your GoTrack password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to set a new password. It expires in %d minutes.\n\n%s\n\n"+
+			"If you did not ask for a password reset you can ignore this message.",
+			user.Username, int(PasswordResetTTL.Minutes()), resetLink(token)),
+	})
+}
+
+// ResetPassword implements Service. Every session of the user is revoked, so
+// whoever knew the old password is logged out.
+func (service *UserService) ResetPassword(ctx *gin.Context) (err error) {
+	var request ResetPasswordRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateResetPassword(); err != nil {
+		return
+	}
+
+	tokenHash := common.HashToken(request.Token)
+
+	reset, err := service.repository.FindPasswordReset(tokenHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("reset token invalid or expired")
+		}
+		return err
+	}
+
+	user, err := service.repository.FindAccount(reset.UserID)
+	if err != nil {
+		return errors.New("reset token invalid or expired")
+	}
+
+	if err = service.checkPasswordPolicy(user.OrganizationID, user.Username, request.Password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := common.HashPassword(request.Password)
+	if err != nil {
+		return errors.New("hashing password failed")
+	}
+
+	user, err = service.repository.ResetPassword(tokenHash, hashedPassword)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("reset token invalid or expired")
+		}
+		return err
+	}
+
+	if err = service.revokeUser(user.ID); err != nil {
+		return err
+	}
+
+	if err = middlewares.Attempts.Reset(usernameKey(user.Username)); err != nil {
+		return errors.New("unable to unlock user")
+	}
+
+	auditSelf(ctx, audit.PasswordReset, user, nil)
+
+	return nil
+}
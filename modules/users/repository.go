@@ -1,7 +1,11 @@
 package users
 
 import (
+	"errors"
 	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"gotrack/modules/organizations"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -9,12 +13,48 @@ import (
 type Repository interface {
 	Login(user LoginRequest) (result User, err error)
 	SignUp(user User) (err error)
-	Update(user User, id int, role string) (err error)
-	Delete(id int) (err error)
-	GetAll(search string, page int, limit int) (users []User, err error)
-	FindByID(id uint) (User, error)
+	Update(orgID uint, user User, id int, role string) (err error)
+	Delete(orgID uint, id int) (err error)
+	GetAll(orgID uint, search string, page int, limit int) (users []User, err error)
+	FindByID(orgID uint, id uint) (User, error)
+	FindAccount(id uint) (User, error)
+	RegisterOrganization(organization *organizations.Organization, user *User) error
 	UpdateIPEmployee(userID uint, ipAddress string) error
 	TrackEmployeeLocation(userID uint, ipAddress string) (geolocation IPInfo, err error)
+	CreateRefreshToken(token RefreshToken) error
+	FindRefreshToken(tokenHash string) (RefreshToken, error)
+	UseRefreshToken(id uint) (bool, error)
+	RevokeRefreshFamily(familyID string) error
+	RevokeRefreshTokensByUser(userID uint) error
+	FindOrganization(id uint) (organizations.Organization, error)
+	SetTOTPSecret(userID uint, secret string, recoveryCodeHashes []string) error
+	EnableTOTP(userID uint) error
+	DisableTOTP(userID uint) error
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	CreatePasswordReset(reset PasswordReset) error
+	FindPasswordReset(tokenHash string) (PasswordReset, error)
+	UpdatePasswordHash(userID uint, hashedPassword string) error
+	ListActiveRefreshTokens(userID uint) ([]RefreshToken, error)
+	FindActiveRefreshFamily(userID uint, familyID string) (RefreshToken, error)
+	CreateUser(user *User) error
+	FindByOIDCSubject(subject string) (User, error)
+	FindUnlinkedByEmail(orgID uint, email string) (User, error)
+	LinkOIDCSubject(userID uint, subject string) error
+	CountPasswordSchemes() (map[string]int64, error)
+	ResetPassword(tokenHash string, hashedPassword string) (User, error)
+	CreateInvitation(invitation *Invitation) error
+	ListPendingInvitations(orgID uint) ([]Invitation, error)
+	RevokeInvitation(orgID uint, id uint) (bool, error)
+	FindPendingInvitation(tokenHash string) (Invitation, error)
+	AcceptInvitation(tokenHash string, user *User) error
+	CreateLoginHistory(login *LoginHistory) error
+	LastLogin(userID uint) (LoginHistory, error)
+	HasLoginFromCountry(userID uint, country string) (bool, error)
+	HasLoginFromDevice(userID uint, userAgent string) (bool, error)
+	ListLoginHistory(orgID uint, userID uint, page int, limit int) ([]LoginHistory, error)
+	ListLoginAnomalies(orgID uint, page int, limit int) ([]LoginHistory, error)
+	FindOwners(orgID uint) ([]User, error)
 }
 
 type userRepository struct {
@@ -49,11 +89,15 @@ func (r *userRepository) SignUp(user User) (err error) {
 	return nil
 }
 
-func (r *userRepository) Update(user User, id int, role string) (err error) {
+func (r *userRepository) Update(orgID uint, user User, id int, role string) (err error) {
 	updateData := map[string]interface{}{
 		"Username": user.Username,
 	}
 
+	if user.Email != "" {
+		updateData["Email"] = user.Email
+	}
+
 	if user.Password != "" {
 		hashedPassword, err := common.HashPassword(user.Password)
 		if err != nil {
@@ -62,31 +106,31 @@ func (r *userRepository) Update(user User, id int, role string) (err error) {
 		updateData["Password"] = hashedPassword
 	}
 
-	// Only add Role to updateData if the user is an owner
-	if role == "owner" {
+	// Only add Role to updateData if the user may manage other users
+	if rbac.Can(orgID, role, rbac.UserUpdate) {
 		updateData["Role"] = user.Role
 	}
 
-	if err := r.db.Model(&User{}).Where("id = ?", id).Updates(updateData).Error; err != nil {
+	if err := r.db.Model(&User{}).Where("id = ? AND organization_id = ?", id, orgID).Updates(updateData).Error; err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r *userRepository) Delete(id int) (err error) {
-	if err := r.db.Where("id = ?", id).Delete(&User{}).Error; err != nil {
+func (r *userRepository) Delete(orgID uint, id int) (err error) {
+	if err := r.db.Where("id = ? AND organization_id = ?", id, orgID).Delete(&User{}).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *userRepository) GetAll(search string, page int, limit int) (users []User, err error) {
+func (r *userRepository) GetAll(orgID uint, search string, page int, limit int) (users []User, err error) {
 	var data []User
-	query := r.db.Model(&User{})
+	query := r.db.Model(&User{}).Where("organization_id = ?", orgID)
 
 	if search != "" {
-		query = query.Where("username LIKE ? OR role LIKE ?",
+		query = query.Where("(username LIKE ? OR role LIKE ?)",
 			"%"+search+"%", "%"+search+"%")
 	}
 
@@ -103,14 +147,38 @@ func (r *userRepository) GetAll(search string, page int, limit int) (users []Use
 	return data, nil
 }
 
-func (r *userRepository) FindByID(id uint) (user User, err error) {
+func (r *userRepository) FindByID(orgID uint, id uint) (user User, err error) {
+
+	if err = r.db.Where("organization_id = ?", orgID).First(&user, id).Error; err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
 
+// FindAccount looks a user up across organizations. It is only meant for
+// authentication flows, where the organization is not known yet.
+func (r *userRepository) FindAccount(id uint) (user User, err error) {
 	if err = r.db.First(&user, id).Error; err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
+func (r *userRepository) RegisterOrganization(organization *organizations.Organization, user *User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+
+		if err := rbac.SeedOrganization(tx, organization.ID); err != nil {
+			return err
+		}
+
+		user.OrganizationID = organization.ID
+		return tx.Create(user).Error
+	})
+}
+
 func (r *userRepository) UpdateIPEmployee(userID uint, ipAddress string) error {
 	return r.db.Model(&User{}).Where("id = ?", userID).Update("ip", ipAddress).Error
 }
@@ -119,3 +187,353 @@ func (r *userRepository) UpdateIPEmployee(userID uint, ipAddress string) error {
 func (r *userRepository) TrackEmployeeLocation(userID uint, ipAddress string) (geolocation IPInfo, err error) {
 	panic("unimplemented")
 }
+
+func (r *userRepository) CreateRefreshToken(token RefreshToken) error {
+	return r.db.Create(&token).Error
+}
+
+func (r *userRepository) FindRefreshToken(tokenHash string) (token RefreshToken, err error) {
+	err = r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	return
+}
+
+// UseRefreshToken marks a token as used. It reports false when another request
+// already used or revoked it, which is treated as reuse by the caller.
+func (r *userRepository) UseRefreshToken(id uint) (bool, error) {
+	result := r.db.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (r *userRepository) RevokeRefreshFamily(familyID string) error {
+	return r.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *userRepository) RevokeRefreshTokensByUser(userID uint) error {
+	return r.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *userRepository) FindOrganization(id uint) (organization organizations.Organization, err error) {
+	err = r.db.First(&organization, id).Error
+	return
+}
+
+// SetTOTPSecret stores a new, not yet confirmed secret and replaces the
+// recovery codes of the user.
+func (r *userRepository) SetTOTPSecret(userID uint, secret string, recoveryCodeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":    secret,
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCode, 0, len(recoveryCodeHashes))
+		for _, hash := range recoveryCodeHashes {
+			codes = append(codes, RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *userRepository) EnableTOTP(userID uint) error {
+	return r.db.Model(&User{}).Where("id = ? AND totp_secret <> ''", userID).Update("totp_enabled", true).Error
+}
+
+func (r *userRepository) DisableTOTP(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPStep records the time step of an accepted code. It reports false when
+// the same or a later step was already used, so a code cannot be replayed.
+func (r *userRepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks a recovery code as used. It reports false when the
+// code does not exist or was already used.
+func (r *userRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// CreatePasswordReset stores a new reset token and drops the unused ones of
+// the same user, so only the latest link works.
+func (r *userRepository) CreatePasswordReset(reset PasswordReset) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND used_at IS NULL", reset.UserID).Delete(&PasswordReset{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&reset).Error
+	})
+}
+
+// ResetPassword consumes a reset token and sets the new password in one
+// transaction. It returns gorm.ErrRecordNotFound for unknown, used or expired
+// tokens.
+func (r *userRepository) ResetPassword(tokenHash string, hashedPassword string) (user User, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var reset PasswordReset
+		if err := tx.Where("token_hash = ?", tokenHash).First(&reset).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&PasswordReset{}).
+			Where("id = ? AND used_at IS NULL AND expired_at > ?", reset.ID, time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.First(&user, reset.UserID).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&user).Update("password", hashedPassword).Error
+	})
+
+	return
+}
+
+// FindPasswordReset only returns tokens that are unused and not expired.
+func (r *userRepository) FindPasswordReset(tokenHash string) (reset PasswordReset, err error) {
+	err = r.db.Where("token_hash = ? AND used_at IS NULL AND expired_at > ?", tokenHash, time.Now()).First(&reset).Error
+	return
+}
+
+func (r *userRepository) UpdatePasswordHash(userID uint, hashedPassword string) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("password", hashedPassword).Error
+}
+
+// CountPasswordSchemes counts users per password hash scheme across all
+// organizations, see common.PasswordScheme.
+func (r *userRepository) CountPasswordSchemes() (map[string]int64, error) {
+	var rows []struct {
+		Scheme string
+		Total  int64
+	}
+
+	err := r.db.Model(&User{}).
+		Select(`CASE
+			WHEN password LIKE '$argon2id$%' THEN ?
+			WHEN password LIKE '$2a$%' OR password LIKE '$2b$%' OR password LIKE '$2y$%' THEN ?
+			ELSE ? END AS scheme, COUNT(*) AS total`,
+			common.SchemeArgon2id, common.SchemeBcrypt, common.SchemeUnknown).
+		Group("scheme").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{
+		common.SchemeArgon2id: 0,
+		common.SchemeBcrypt:   0,
+		common.SchemeUnknown:  0,
+	}
+	for _, row := range rows {
+		counts[row.Scheme] = row.Total
+	}
+
+	return counts, nil
+}
+
+// ListActiveRefreshTokens returns the current token of every live refresh
+// family of a user, newest login first.
+func (r *userRepository) ListActiveRefreshTokens(userID uint) (tokens []RefreshToken, err error) {
+	err = r.db.Where("user_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expired_at > ?", userID, time.Now()).
+		Order("login_at DESC").
+		Find(&tokens).Error
+	return
+}
+
+func (r *userRepository) FindActiveRefreshFamily(userID uint, familyID string) (token RefreshToken, err error) {
+	err = r.db.Where("user_id = ? AND family_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expired_at > ?", userID, familyID, time.Now()).
+		First(&token).Error
+	return
+}
+
+// CreateUser is SignUp for callers that need the new id.
+func (r *userRepository) CreateUser(user *User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *userRepository) FindByOIDCSubject(subject string) (user User, err error) {
+	err = r.db.Where("oidc_subject = ?", subject).First(&user).Error
+	return
+}
+
+// FindUnlinkedByEmail only matches users not linked to an identity yet.
+func (r *userRepository) FindUnlinkedByEmail(orgID uint, email string) (user User, err error) {
+	err = r.db.Where("organization_id = ? AND LOWER(email) = LOWER(?) AND (oidc_subject = '' OR oidc_subject IS NULL)", orgID, email).
+		First(&user).Error
+	return
+}
+
+func (r *userRepository) LinkOIDCSubject(userID uint, subject string) error {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND (oidc_subject = '' OR oidc_subject IS NULL)", userID).
+		Update("oidc_subject", subject)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return errors.New("account is already linked to another identity")
+	}
+
+	return nil
+}
+
+func (r *userRepository) CreateInvitation(invitation *Invitation) error {
+	return r.db.Create(invitation).Error
+}
+
+// ListPendingInvitations returns invitations that can still be accepted.
+func (r *userRepository) ListPendingInvitations(orgID uint) (invitations []Invitation, err error) {
+	err = r.db.Where("organization_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expired_at > ?", orgID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return
+}
+
+// RevokeInvitation reports false when the invitation does not exist in the
+// organization or is no longer pending.
+func (r *userRepository) RevokeInvitation(orgID uint, id uint) (bool, error) {
+	result := r.db.Model(&Invitation{}).
+		Where("id = ? AND organization_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id, orgID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (r *userRepository) FindPendingInvitation(tokenHash string) (invitation Invitation, err error) {
+	err = r.db.Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expired_at > ?", tokenHash, time.Now()).
+		First(&invitation).Error
+	return
+}
+
+// AcceptInvitation consumes an invitation and creates its user in one
+// transaction, so a token can never create two accounts. It returns
+// gorm.ErrRecordNotFound when the invitation is no longer pending.
+func (r *userRepository) AcceptInvitation(tokenHash string, user *User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var invitation Invitation
+		if err := tx.Where("token_hash = ?", tokenHash).First(&invitation).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expired_at > ?", invitation.ID, time.Now()).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Invitation{}).Where("id = ?", invitation.ID).Update("accepted_by", user.ID).Error
+	})
+}
+
+func (r *userRepository) CreateLoginHistory(login *LoginHistory) error {
+	return r.db.Create(login).Error
+}
+
+// LastLogin returns gorm.ErrRecordNotFound for users without any history.
+func (r *userRepository) LastLogin(userID uint) (login LoginHistory, err error) {
+	err = r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").First(&login).Error
+	return
+}
+
+func (r *userRepository) HasLoginFromCountry(userID uint, country string) (bool, error) {
+	var count int64
+	err := r.db.Model(&LoginHistory{}).Where("user_id = ? AND country = ?", userID, country).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *userRepository) HasLoginFromDevice(userID uint, userAgent string) (bool, error) {
+	var count int64
+	err := r.db.Model(&LoginHistory{}).Where("user_id = ? AND user_agent = ?", userID, userAgent).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+func paginate(query *gorm.DB, page int, limit int) *gorm.DB {
+	if page > 0 && limit > 0 {
+		offset := (page - 1) * limit
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	return query
+}
+
+func (r *userRepository) ListLoginHistory(orgID uint, userID uint, page int, limit int) (logins []LoginHistory, err error) {
+	query := r.db.Where("organization_id = ? AND user_id = ?", orgID, userID).Order("created_at DESC, id DESC")
+	err = paginate(query, page, limit).Find(&logins).Error
+	return
+}
+
+// ListLoginAnomalies returns the flagged logins of an organization.
+func (r *userRepository) ListLoginAnomalies(orgID uint, page int, limit int) (logins []LoginHistory, err error) {
+	query := r.db.Where("organization_id = ? AND flagged", orgID).Order("created_at DESC, id DESC")
+	err = paginate(query, page, limit).Find(&logins).Error
+	return
+}
+
+func (r *userRepository) FindOwners(orgID uint) (owners []User, err error) {
+	err = r.db.Where("organization_id = ? AND role = ?", orgID, rbac.OwnerRole).Find(&owners).Error
+	return
+}
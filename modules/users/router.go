@@ -1,15 +1,27 @@
 package users
 
 import (
+	"gotrack/helpers/rbac"
 	"gotrack/middlewares"
 
 	"github.com/gin-gonic/gin"
 )
 
 func Initiator(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", JWKS)
+
 	api := router.Group("/api/users")
 	{
 		api.POST("/login", Login)
+		api.POST("/login/2fa", LoginTwoFactor)
+		api.POST("/login/2fa/enroll", EnrollTwoFactorLogin)
+		api.GET("/oidc/login", OIDCLogin)
+		api.GET("/oidc/callback", OIDCCallback)
+		api.POST("/token/refresh", Refresh)
+		api.POST("/register", RegisterOrganization)
+		api.POST("/password/forgot", ForgotPassword)
+		api.POST("/password/reset", ResetPassword)
+		api.POST("/invitations/accept", AcceptInvitation)
 	}
 
 	auth := router.Group("/api/users")
@@ -17,10 +29,26 @@ func Initiator(router *gin.Engine) {
 	auth.Use(middlewares.Logging())
 	{
 		auth.PUT(":id", Update)
-		auth.POST("/signup", middlewares.AuthorizeRole("owner"), SignUp)
-		auth.GET("", middlewares.AuthorizeRole("owner"), GetList)
-		auth.GET(":id", middlewares.AuthorizeRole("owner"), GetByID)
-		auth.DELETE(":id", middlewares.AuthorizeRole("owner"), Delete)
-		auth.POST("/track", middlewares.AuthorizeRole("owner"), Track)
+		auth.POST("/signup", middlewares.RequirePermission(rbac.UserCreate), SignUp)
+		auth.GET("", middlewares.RequirePermission(rbac.UserRead), GetList)
+		auth.GET(":id", middlewares.RequirePermission(rbac.UserRead), GetByID)
+		auth.DELETE(":id", middlewares.RequirePermission(rbac.UserDelete), Delete)
+		auth.POST("/track", middlewares.RequirePermission(rbac.UserTrack), Track)
+		auth.POST("/logout", Logout)
+		auth.POST(":id/sessions/revoke", middlewares.RequirePermission(rbac.UserRevokeSession), RevokeSessions)
+		auth.POST(":id/unlock", middlewares.RequirePermission(rbac.UserUnlock), Unlock)
+		auth.POST("/me/2fa/enroll", middlewares.DenyImpersonation(), EnrollTwoFactor)
+		auth.POST("/me/2fa/confirm", middlewares.DenyImpersonation(), ConfirmTwoFactor)
+		auth.DELETE("/me/2fa", middlewares.DenyImpersonation(), DisableTwoFactor)
+		auth.GET("/me/sessions", ListMySessions)
+		auth.DELETE("/me/sessions/:sessionId", RevokeMySession)
+		auth.GET(":id/sessions", middlewares.RequirePermission(rbac.UserReadSession), ListSessions)
+		auth.DELETE(":id/sessions/:sessionId", middlewares.RequirePermission(rbac.UserRevokeSession), RevokeSession)
+		auth.POST("/invitations", middlewares.RequirePermission(rbac.UserCreate), CreateInvitation)
+		auth.GET("/invitations", middlewares.RequirePermission(rbac.UserCreate), ListInvitations)
+		auth.DELETE("/invitations/:invitationId", middlewares.RequirePermission(rbac.UserCreate), RevokeInvitation)
+		auth.GET(":id/logins", middlewares.RequirePermission(rbac.UserReadLogin), ListLoginHistory)
+		auth.GET("/logins/anomalies", middlewares.RequirePermission(rbac.UserReadLogin), ListLoginAnomalies)
+		auth.POST(":id/impersonate", middlewares.DenyImpersonation(), middlewares.RequirePermission(rbac.UserImpersonate), Impersonate)
 	}
 }
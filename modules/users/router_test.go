@@ -0,0 +1,79 @@
+package users
+
+import (
+	"gotrack/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// officeOnly allows every role of every organization from the office only.
+type officeOnly struct{}
+
+func (officeOnly) Allowed(orgID uint, role, ip string) (bool, error) {
+	return ip == "10.0.0.1", nil
+}
+
+func TestAllowlistCoversRoutesWithoutPermissionCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("jwt_secret_key", "test-secret")
+	if err := middlewares.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	middlewares.Allowlists = officeOnly{}
+	t.Cleanup(func() { middlewares.Allowlists = nil })
+
+	session := middlewares.UserLoginRedis{
+		TokenID:        "allowlist-test-token",
+		UserId:         1,
+		OrganizationID: 1,
+		Username:       "owner",
+		Role:           "owner",
+		LoginAt:        time.Now(),
+		ExpiredAt:      time.Now().Add(time.Minute),
+	}
+	if err := middlewares.Sessions.Put(session.TokenID, session); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { middlewares.Sessions.Delete(session.TokenID) })
+
+	token, err := middlewares.GenerateJwtToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	Initiator(router)
+
+	// PUT /api/users/:id changes roles and passwords without RequirePermission
+	request := httptest.NewRequest(http.MethodPut, "/api/users/2", strings.NewReader(`{"role":"owner","password":"stolen"}`))
+	request.RemoteAddr = "203.0.113.5:4711"
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), middlewares.ErrIPNotAllowed.Error()) {
+		t.Errorf("PUT from outside the allowlist = %d %s, want 403", recorder.Code, recorder.Body)
+	}
+
+	// the same token is accepted from the office
+	reached := false
+	office := gin.New()
+	office.GET("/", middlewares.JwtMiddleware(), func(ctx *gin.Context) { reached = true })
+
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.0.0.1:4711"
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	office.ServeHTTP(httptest.NewRecorder(), request)
+	if !reached {
+		t.Error("token rejected from an allowed address")
+	}
+}
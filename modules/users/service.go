@@ -3,8 +3,15 @@ package users
 import (
 	"errors"
 	"fmt"
+	"gotrack/helpers/audit"
 	"gotrack/helpers/common"
+	"gotrack/helpers/password"
+	"gotrack/helpers/rbac"
 	"gotrack/middlewares"
+	"io"
+	"log"
+	"net"
+	"os"
 	"strconv"
 	"time"
 
@@ -21,8 +28,37 @@ type Service interface {
 	Update(ctx *gin.Context) (err error)
 	Delete(ctx *gin.Context) (err error)
 	Track(ctx *gin.Context) (interface{}, error)
+	Logout(ctx *gin.Context) (err error)
+	RevokeSessions(ctx *gin.Context) (err error)
+	Refresh(ctx *gin.Context) (result LoginResponse, err error)
+	RegisterOrganization(ctx *gin.Context) (err error)
+	Unlock(ctx *gin.Context) (err error)
+	LoginTwoFactor(ctx *gin.Context) (result LoginResponse, err error)
+	EnrollTwoFactorLogin(ctx *gin.Context) (result TwoFactorEnrollment, err error)
+	EnrollTwoFactor(ctx *gin.Context) (result TwoFactorEnrollment, err error)
+	ConfirmTwoFactor(ctx *gin.Context) (err error)
+	DisableTwoFactor(ctx *gin.Context) (err error)
+	ForgotPassword(ctx *gin.Context) (err error)
+	ResetPassword(ctx *gin.Context) (err error)
+	ListMySessions(ctx *gin.Context) (result []Session, err error)
+	RevokeMySession(ctx *gin.Context) (err error)
+	ListSessions(ctx *gin.Context) (result []Session, err error)
+	RevokeSession(ctx *gin.Context) (err error)
+	OIDCLogin(ctx *gin.Context) (authURL string, err error)
+	OIDCCallback(ctx *gin.Context) (result LoginResponse, err error)
+	CreateInvitation(ctx *gin.Context) (result CreatedInvitation, err error)
+	ListInvitations(ctx *gin.Context) (result []Invitation, err error)
+	RevokeInvitation(ctx *gin.Context) (err error)
+	AcceptInvitation(ctx *gin.Context) (err error)
+	Impersonate(ctx *gin.Context) (result ImpersonationResponse, err error)
+	ListLoginHistory(ctx *gin.Context) (result []LoginHistory, err error)
+	ListLoginAnomalies(ctx *gin.Context) (result []LoginHistory, err error)
 }
 
+// RefreshTokenTTL bounds how long a session can be kept alive through
+// refresh token rotation without logging in again.
+var RefreshTokenTTL = 7 * 24 * time.Hour
+
 type UserService struct {
 	repository Repository
 	validate   *validator.Validate
@@ -48,52 +84,281 @@ func (service *UserService) LoginService(ctx *gin.Context) (result LoginResponse
 		return
 	}
 
+	// Get the IP address from the request
+	ipAddress := ctx.ClientIP()
+
+	if err = checkLoginAllowed(userReq.Username, ipAddress); err != nil {
+		// looked up so the row belongs to the organization of the user
+		user, _ := service.repository.Login(userReq)
+		auditUser(ctx, audit.LoginLocked, user, map[string]interface{}{"username": userReq.Username})
+		return
+	}
+
 	user, err := service.repository.Login(userReq)
 	if err != nil {
 		return
 	}
 
+	// compare against a dummy hash for unknown users as well, so response
+	// times do not tell them apart from wrong passwords
+	hashedPassword := user.Password
 	if common.IsEmptyField(user.ID) {
-		err = errors.New("invalid account")
+		hashedPassword = dummyPasswordHash()
+	}
+
+	matches := common.CheckPassword(hashedPassword, userReq.Password)
+	if !matches || common.IsEmptyField(user.ID) {
+		auditUser(ctx, audit.LoginFailure, user, map[string]interface{}{"username": userReq.Username})
+
+		if err = recordLoginFailure(userReq.Username, ipAddress); err != nil {
+			err = errors.New("unable to record login attempt")
+			return
+		}
+
+		err = errInvalidCredentials
 		return
 	}
 
-	matches := common.CheckPassword(user.Password, userReq.Password)
-	if !matches {
-		err = errors.New("wrong username or password")
+	if err = recordLoginSuccess(userReq.Username); err != nil {
+		err = errors.New("unable to record login attempt")
 		return
 	}
 
-	jwtToken, err := middlewares.GenerateJwtToken()
+	// the plain password is only known here, so legacy hashes are upgraded
+	// on login; a failure must not block the login itself
+	if common.NeedsRehash(user.Password) {
+		if err = service.upgradePasswordHash(user.ID, userReq.Password); err != nil {
+			log.Printf("upgrade password hash of user %d: %v", user.ID, err)
+			err = nil
+		}
+	}
+
+	// checked before the second factor, so a restricted user is not asked
+	// for a code that cannot get them in
+	if err = checkNetwork(ctx, user); err != nil {
+		return
+	}
+
+	required, err := service.twoFactorRequired(user)
 	if err != nil {
 		return
 	}
 
-	middlewares.DummyRedis[jwtToken] = middlewares.UserLoginRedis{
-		UserId:    int64(user.ID),
-		Username:  user.Username,
-		Role:      user.Role,
-		LoginAt:   time.Now(),
-		ExpiredAt: time.Now().Add(time.Hour * 2),
+	if required {
+		return service.twoFactorChallenge(user)
 	}
 
-	// Get the IP address from the request
-	ipAddress := ctx.ClientIP()
+	return service.completeLogin(ctx, user, "password")
+}
+
+// completeLogin issues the session once every login step has passed. method
+// names the final step for the audit log.
+func (service *UserService) completeLogin(ctx *gin.Context, user User, method string) (result LoginResponse, err error) {
+	if err = checkNetwork(ctx, user); err != nil {
+		return
+	}
+
+	result, err = service.issueTokens(ctx, user, "", time.Now())
+	if err != nil {
+		return
+	}
 
 	// ctx.JSON(http.StatusOK, gin.H{"ip": ipAddress})
 
 	// Update or create IP info for the user
-	if err = service.repository.UpdateIPEmployee(user.ID, ipAddress); err != nil {
-		common.GenerateErrorResponse(ctx, "Failed to update IP information")
+	if err = service.repository.UpdateIPEmployee(user.ID, ctx.ClientIP()); err != nil {
+		err = errors.New("failed to update IP information")
+		return
+	}
+
+	auditSelf(ctx, audit.LoginSuccess, user, map[string]interface{}{"method": method})
+
+	go service.recordLogin(loginEntry(ctx, user), user, method)
+
+	return
+}
+
+// issueTokens creates an access token with its session and a new refresh
+// token. An empty familyID starts a new refresh token family (a fresh login).
+func (service *UserService) issueTokens(ctx *gin.Context, user User, familyID string, loginAt time.Time) (result LoginResponse, err error) {
+	if familyID == "" {
+		if familyID, err = common.GenerateRandomToken(24); err != nil {
+			return
+		}
+	}
+
+	tokenID, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return
+	}
+
+	session := middlewares.UserLoginRedis{
+		TokenID:        tokenID,
+		UserId:         int64(user.ID),
+		OrganizationID: user.OrganizationID,
+		Username:       user.Username,
+		Role:           user.Role,
+		RefreshFamily:  familyID,
+		UserAgent:      userAgent(ctx),
+		ClientIP:       ctx.ClientIP(),
+		LoginAt:        loginAt,
+		ExpiredAt:      time.Now().Add(middlewares.AccessTokenTTL),
+	}
+
+	jwtToken, err := middlewares.GenerateJwtToken(session)
+	if err != nil {
+		return
+	}
+
+	if err = middlewares.Sessions.Put(tokenID, session); err != nil {
+		err = errors.New("unable to store session")
+		return
+	}
+
+	refreshToken, err := common.GenerateRandomToken(32)
+	if err != nil {
+		return
+	}
+
+	err = service.repository.CreateRefreshToken(RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: common.HashToken(refreshToken),
+		UserAgent: session.UserAgent,
+		ClientIP:  session.ClientIP,
+		LoginAt:   loginAt,
+		ExpiredAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		err = errors.New("unable to store refresh token")
 		return
 	}
 
 	result.Token = jwtToken
+	result.ExpiredAt = session.ExpiredAt
+	result.RefreshToken = refreshToken
 
 	return
 }
 
+func (service *UserService) upgradePasswordHash(userID uint, plainPassword string) error {
+	hashedPassword, err := common.HashPassword(plainPassword)
+	if err != nil {
+		return err
+	}
+
+	return service.repository.UpdatePasswordHash(userID, hashedPassword)
+}
+
+// checkPasswordPolicy applies the password policy of an organization.
+func (service *UserService) checkPasswordPolicy(orgID uint, username, newPassword string) error {
+	organization, err := service.repository.FindOrganization(orgID)
+	if err != nil {
+		return errors.New("unable to load password policy")
+	}
+
+	return organization.PasswordPolicy.Check(newPassword, username)
+}
+
+// checkNetwork applies the IP allowlist of the organization to the role of
+// user. Denied attempts are audited.
+func checkNetwork(ctx *gin.Context, user User) error {
+	err := middlewares.CheckAllowedIP(user.OrganizationID, user.Role, ctx.ClientIP())
+	if errors.Is(err, middlewares.ErrIPNotAllowed) {
+		auditSelf(ctx, audit.LoginDenied, user, map[string]interface{}{"reason": "ip allowlist"})
+	}
+
+	return err
+}
+
+// userAgent returns the User-Agent header, cut to fit its column.
+func userAgent(ctx *gin.Context) string {
+	agent := ctx.Request.UserAgent()
+	if len(agent) > 255 {
+		agent = agent[:255]
+	}
+
+	return agent
+}
+
+// revokeUser drops every session and refresh token of a user.
+func (service *UserService) revokeUser(userID uint) error {
+	if err := middlewares.RevokeUserSessions(int64(userID)); err != nil {
+		return errors.New("unable to revoke user sessions")
+	}
+
+	if err := service.repository.RevokeRefreshTokensByUser(userID); err != nil {
+		return errors.New("unable to revoke user refresh tokens")
+	}
+
+	return nil
+}
+
+// Refresh implements Service.
+func (service *UserService) Refresh(ctx *gin.Context) (result LoginResponse, err error) {
+	var request RefreshRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateRefresh(); err != nil {
+		return
+	}
+
+	token, err := service.repository.FindRefreshToken(common.HashToken(request.RefreshToken))
+	if err != nil {
+		err = errors.New("invalid refresh token")
+		return
+	}
+
+	used, err := service.repository.UseRefreshToken(token.ID)
+	if err != nil {
+		return
+	}
+
+	// a rotated or revoked token showing up again means it leaked, so that
+	// login is locked out; the user's other devices are not affected
+	if !used {
+		if err = service.repository.RevokeRefreshFamily(token.FamilyID); err != nil {
+			return
+		}
+		if err = middlewares.RevokeSessionFamily(int64(token.UserID), token.FamilyID); err != nil {
+			return
+		}
+
+		if user, findErr := service.repository.FindAccount(token.UserID); findErr == nil {
+			auditUser(ctx, audit.TokenRefreshReuse, user, map[string]interface{}{"session_id": token.FamilyID})
+		}
+
+		err = errors.New("refresh token already used, please log in again")
+		return
+	}
+
+	if time.Now().After(token.ExpiredAt) {
+		err = errors.New("refresh token expired, please log in again")
+		return
+	}
+
+	user, err := service.repository.FindAccount(token.UserID)
+	if err != nil {
+		err = errors.New("invalid account")
+		return
+	}
+
+	if err = checkNetwork(ctx, user); err != nil {
+		return
+	}
+
+	return service.issueTokens(ctx, user, token.FamilyID, token.LoginAt)
+}
+
 func (service *UserService) SignUpService(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
 	var userReq SignUpRequest
 
 	err = ctx.ShouldBind(&userReq)
@@ -106,16 +371,28 @@ func (service *UserService) SignUpService(ctx *gin.Context) (err error) {
 		return err
 	}
 
+	if !rbac.RoleExists(loginData.OrganizationID, userReq.Role) {
+		return errors.New("role does not exist")
+	}
+
+	if err = service.checkPasswordPolicy(loginData.OrganizationID, userReq.Username, userReq.Password); err != nil {
+		return err
+	}
+
 	user, err := userReq.ConvertToModelForSignUp()
 	if err != nil {
 		return err
 	}
 
-	err = service.repository.SignUp(user)
+	user.OrganizationID = loginData.OrganizationID
+
+	err = service.repository.CreateUser(&user)
 	if err != nil {
 		return err
 	}
 
+	auditUser(ctx, audit.UserSignUp, user, map[string]interface{}{"username": user.Username, "role": user.Role})
+
 	return nil
 }
 
@@ -125,7 +402,12 @@ func (service *UserService) GetAll(ctx *gin.Context) (result []User, err error)
 	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))    // Default to page 1
 	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "10")) // Default to limit 10
 
-	return service.repository.GetAll(search, page, limit)
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.repository.GetAll(loginData.OrganizationID, search, page, limit)
 }
 
 // Update implements Service.
@@ -152,8 +434,12 @@ func (service *UserService) Update(ctx *gin.Context) (err error) {
 		return errors.New("validation failed: " + err.Error())
 	}
 
+	if !common.IsEmptyField(request.Role) && !rbac.RoleExists(loginData.OrganizationID, request.Role) {
+		return errors.New("role does not exist")
+	}
+
 	var id int
-	if loginData.Role == "owner" {
+	if rbac.Can(loginData.OrganizationID, loginData.Role, rbac.UserUpdate) {
 		id, err = strconv.Atoi(ctx.Param("id"))
 		if err != nil {
 			return fmt.Errorf("invalid ID format")
@@ -167,28 +453,74 @@ func (service *UserService) Update(ctx *gin.Context) (err error) {
 	// 	return fmt.Errorf("invalid ID format")
 	// }
 
+	existing, err := service.repository.FindByID(loginData.OrganizationID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user with given ID does not exist")
+		}
+		return err
+	}
+
+	if err = validateEmail(request.Email); err != nil {
+		return err
+	}
+
+	if request.Password != "" {
+		if loginData.IsImpersonated() {
+			return errors.New("password cannot be changed while impersonating")
+		}
+
+		username := request.Username
+		if username == "" {
+			username = existing.Username
+		}
+
+		if err = service.checkPasswordPolicy(loginData.OrganizationID, username, request.Password); err != nil {
+			return err
+		}
+	}
+
 	user := User{
 		Username: request.Username,
+		Email:    request.Email,
 		Password: request.Password,
 		Role:     request.Role,
 		IP:       "",
 	}
 
-	if err = service.repository.Update(user, id, loginData.Role); err != nil {
+	if err = service.repository.Update(loginData.OrganizationID, user, id, loginData.Role); err != nil {
 		return err
 	}
 
+	auditUser(ctx, audit.UserUpdate, existing, map[string]interface{}{"fields": updatedFields(request)})
+
+	// sessions carry the role, so a role change must force a new login
+	if rbac.Can(loginData.OrganizationID, loginData.Role, rbac.UserUpdate) && request.Role != existing.Role {
+		if err = service.revokeUser(uint(id)); err != nil {
+			return err
+		}
+
+		if request.Role != "" {
+			auditUser(ctx, audit.UserRoleChange, existing, map[string]interface{}{"from": existing.Role, "to": request.Role})
+		}
+	}
+
 	return nil
 }
 
 // Delete implements Service.
 func (service *UserService) Delete(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		return fmt.Errorf("invalid ID format")
 	}
 
-	exists, err := service.repository.FindByID(uint(id))
+	exists, err := service.repository.FindByID(loginData.OrganizationID, uint(id))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("user with given ID does not exist")
@@ -200,20 +532,31 @@ func (service *UserService) Delete(ctx *gin.Context) (err error) {
 		return errors.New("user with given ID does not exist")
 	}
 
-	if err = service.repository.Delete(id); err != nil {
+	if err = service.repository.Delete(loginData.OrganizationID, id); err != nil {
 		return err
 	}
 
+	if err = service.revokeUser(uint(id)); err != nil {
+		return err
+	}
+
+	auditUser(ctx, audit.UserDelete, exists, map[string]interface{}{"username": exists.Username})
+
 	return nil
 }
 
 func (service *UserService) FindByID(ctx *gin.Context) (User, error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		return User{}, fmt.Errorf("invalid ID format")
 	}
 
-	data, err := service.repository.FindByID(uint(id))
+	data, err := service.repository.FindByID(loginData.OrganizationID, uint(id))
 	if err != nil {
 		return User{}, err
 	}
@@ -223,6 +566,11 @@ func (service *UserService) FindByID(ctx *gin.Context) (User, error) {
 
 // Track implements Service.
 func (service *UserService) Track(ctx *gin.Context) (interface{}, error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var request struct {
 		UserID uint `json:"user_id" binding:"required"`
 	}
@@ -230,7 +578,7 @@ func (service *UserService) Track(ctx *gin.Context) (interface{}, error) {
 		return nil, errors.New("invalid request")
 	}
 
-	user, err := service.repository.FindByID(request.UserID)
+	user, err := service.repository.FindByID(loginData.OrganizationID, request.UserID)
 	if err != nil {
 		return nil, errors.New("id employee tidak ditemukan")
 	}
@@ -243,3 +591,129 @@ func (service *UserService) Track(ctx *gin.Context) (interface{}, error) {
 	// geoLocation.UserID = user.ID
 	return geoLocation, nil
 }
+
+// Logout implements Service.
+func (service *UserService) Logout(ctx *gin.Context) (err error) {
+	session, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = middlewares.RevokeSession(session); err != nil {
+		return errors.New("unable to revoke session")
+	}
+
+	if session.RefreshFamily != "" {
+		if err = service.repository.RevokeRefreshFamily(session.RefreshFamily); err != nil {
+			return errors.New("unable to revoke refresh token")
+		}
+	}
+
+	audit.Record(middlewares.AuditEntry(ctx, audit.Logout), nil)
+
+	return nil
+}
+
+// RevokeSessions implements Service.
+func (service *UserService) RevokeSessions(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	user, err := service.repository.FindByID(loginData.OrganizationID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user with given ID does not exist")
+		}
+		return err
+	}
+
+	if err = service.revokeUser(user.ID); err != nil {
+		return err
+	}
+
+	auditUser(ctx, audit.UserSessionsRevoke, user, nil)
+
+	return nil
+}
+
+// RegisterOrganization implements Service.
+func (service *UserService) RegisterOrganization(ctx *gin.Context) (err error) {
+	if os.Getenv("ALLOW_ORGANIZATION_SIGNUP") != "true" {
+		return errors.New("organization sign up is disabled")
+	}
+
+	var request RegisterOrganizationRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return err
+	}
+
+	if err = request.ValidateRegister(); err != nil {
+		return err
+	}
+
+	// the organization does not exist yet, so its policy is the default one
+	if err = password.DefaultPolicy.Check(request.Password, request.Username); err != nil {
+		return err
+	}
+
+	organization, user, err := request.ConvertToModel()
+	if err != nil {
+		return err
+	}
+
+	if err = service.repository.RegisterOrganization(&organization, &user); err != nil {
+		return err
+	}
+
+	auditSelf(ctx, audit.OrganizationRegister, user, map[string]interface{}{"organization": organization.Name})
+
+	return rbac.Reload()
+}
+
+// Unlock implements Service.
+func (service *UserService) Unlock(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	user, err := service.repository.FindByID(loginData.OrganizationID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user with given ID does not exist")
+		}
+		return err
+	}
+
+	var request UnlockRequest
+
+	// the body is optional
+	if err = ctx.ShouldBind(&request); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("invalid request")
+	}
+
+	if request.IP != "" && net.ParseIP(request.IP) == nil {
+		return errors.New("invalid IP address")
+	}
+
+	if err = unlockLogin(user.Username, user.IP, request.IP); err != nil {
+		return errors.New("unable to unlock user")
+	}
+
+	auditUser(ctx, audit.UserUnlock, user, map[string]interface{}{"last_login_ip": user.IP, "ip": request.IP})
+
+	return nil
+}
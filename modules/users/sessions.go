@@ -0,0 +1,123 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"gotrack/helpers/audit"
+	"gotrack/middlewares"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (service *UserService) listSessions(userID uint, currentFamily string) ([]Session, error) {
+	tokens, err := service.repository.ListActiveRefreshTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, token.ConvertToSession(currentFamily))
+	}
+
+	return sessions, nil
+}
+
+// revokeSession ends one login: its refresh token family and every access
+// token issued from it.
+func (service *UserService) revokeSession(ctx *gin.Context, user User, sessionID string) error {
+	if _, err := service.repository.FindActiveRefreshFamily(user.ID, sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("session does not exist")
+		}
+		return err
+	}
+
+	if err := service.repository.RevokeRefreshFamily(sessionID); err != nil {
+		return errors.New("unable to revoke refresh token")
+	}
+
+	if err := middlewares.RevokeSessionFamily(int64(user.ID), sessionID); err != nil {
+		return errors.New("unable to revoke session")
+	}
+
+	auditUser(ctx, audit.UserSessionRevoke, user, map[string]interface{}{"session_id": sessionID})
+
+	return nil
+}
+
+// userFromParam resolves the :id user within the organization of the caller.
+func (service *UserService) userFromParam(ctx *gin.Context, orgID uint) (User, error) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return User{}, fmt.Errorf("invalid ID format")
+	}
+
+	user, err := service.repository.FindByID(orgID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, errors.New("user with given ID does not exist")
+		}
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ListMySessions implements Service.
+func (service *UserService) ListMySessions(ctx *gin.Context) (result []Session, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	return service.listSessions(uint(loginData.UserId), loginData.RefreshFamily)
+}
+
+// RevokeMySession implements Service.
+func (service *UserService) RevokeMySession(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	user := User{
+		OrganizationID: loginData.OrganizationID,
+		Username:       loginData.Username,
+	}
+	user.ID = uint(loginData.UserId)
+
+	return service.revokeSession(ctx, user, ctx.Param("sessionId"))
+}
+
+// ListSessions implements Service.
+func (service *UserService) ListSessions(ctx *gin.Context) (result []Session, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	user, err := service.userFromParam(ctx, loginData.OrganizationID)
+	if err != nil {
+		return
+	}
+
+	return service.listSessions(user.ID, loginData.RefreshFamily)
+}
+
+// RevokeSession implements Service.
+func (service *UserService) RevokeSession(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	user, err := service.userFromParam(ctx, loginData.OrganizationID)
+	if err != nil {
+		return
+	}
+
+	return service.revokeSession(ctx, user, ctx.Param("sessionId"))
+}
@@ -0,0 +1,393 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gotrack/helpers/audit"
+	"gotrack/helpers/common"
+	"gotrack/helpers/rbac"
+	"gotrack/helpers/totp"
+	"gotrack/middlewares"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	twoFactorPurpose = "2fa"
+
+	// TwoFactorChallengeTTL is how long the password step of a login stays
+	// valid while waiting for the second factor.
+	TwoFactorChallengeTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+
+	return "GoTrack"
+}
+
+// twoFactorRequired reports whether a login of user needs a second factor:
+// always once the user enrolled, and for owners whose organization requires it.
+func (service *UserService) twoFactorRequired(user User) (bool, error) {
+	if user.TOTPEnabled {
+		return true, nil
+	}
+
+	if user.Role != rbac.OwnerRole {
+		return false, nil
+	}
+
+	organization, err := service.repository.FindOrganization(user.OrganizationID)
+	if err != nil {
+		return false, errors.New("unable to load organization")
+	}
+
+	return organization.RequireOwnerTwoFactor, nil
+}
+
+func (service *UserService) twoFactorChallenge(user User) (result LoginResponse, err error) {
+	token, _, err := middlewares.GenerateChallengeToken(user.ID, twoFactorPurpose, TwoFactorChallengeTTL, nil)
+	if err != nil {
+		return
+	}
+
+	result.TwoFactorRequired = true
+	result.EnrollmentRequired = !user.TOTPEnabled
+	result.ChallengeToken = token
+
+	return
+}
+
+// parseChallenge resolves a challenge token to its user and refuses tokens
+// that already completed a login.
+func (service *UserService) parseChallenge(token string) (user User, claims *middlewares.ChallengeClaims, err error) {
+	userID, claims, err := middlewares.ParseChallengeToken(token, twoFactorPurpose)
+	if err != nil {
+		return
+	}
+
+	usedUntil, err := middlewares.Attempts.LockedUntil(challengeUsedKey(claims.Id))
+	if err != nil {
+		err = errors.New("unable to verify challenge")
+		return
+	}
+
+	if time.Now().Before(usedUntil) {
+		err = errors.New("challenge invalid or expired, please log in again")
+		return
+	}
+
+	user, err = service.repository.FindAccount(userID)
+	if err != nil {
+		err = errors.New("challenge invalid or expired, please log in again")
+		return
+	}
+
+	return
+}
+
+func challengeUsedKey(tokenID string) string {
+	return "2fa:challenge:" + tokenID
+}
+
+// verifyTOTP checks a code and consumes its time step, so every code works
+// only once.
+func (service *UserService) verifyTOTP(user User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errInvalidTwoFactorCode
+	}
+
+	fresh, err := service.repository.UseTOTPStep(user.ID, step)
+	if err != nil {
+		return err
+	}
+
+	if !fresh {
+		return errInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// verifySecondFactor accepts either a TOTP code or, once enrolled, a recovery
+// code. Failures are throttled per user.
+func (service *UserService) verifySecondFactor(ctx *gin.Context, user User, code, recoveryCode string) (err error) {
+	key := twoFactorKey(user.ID)
+
+	if err = checkNotLocked(key); err != nil {
+		return
+	}
+
+	if !common.IsEmptyField(recoveryCode) && user.TOTPEnabled {
+		var used bool
+		used, err = service.repository.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+		if err == nil && !used {
+			err = errInvalidTwoFactorCode
+		}
+	} else {
+		err = service.verifyTOTP(user, code)
+	}
+
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		auditUser(ctx, audit.TwoFactorFailure, user, nil)
+
+		if failErr := twoFactorThrottle.fail(key); failErr != nil {
+			return errors.New("unable to record login attempt")
+		}
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	return middlewares.Attempts.Reset(key)
+}
+
+// newEnrollment generates and stores a fresh, unconfirmed secret together
+// with new recovery codes. The plain codes are only returned here.
+func (service *UserService) newEnrollment(user User) (result TwoFactorEnrollment, err error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		var code string
+		if code, err = generateRecoveryCode(); err != nil {
+			return
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	if err = service.repository.SetTOTPSecret(user.ID, secret, hashes); err != nil {
+		err = errors.New("unable to store two-factor secret")
+		return
+	}
+
+	result.Secret = secret
+	result.URI = totp.URI(totpIssuer(), user.Username, secret)
+	result.RecoveryCodes = codes
+
+	return
+}
+
+// generateRecoveryCode returns a code like "3f9a1-c07be".
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := hex.EncodeToString(buf)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return common.HashToken(code)
+}
+
+// LoginTwoFactor implements Service.
+func (service *UserService) LoginTwoFactor(ctx *gin.Context) (result LoginResponse, err error) {
+	var request TwoFactorLoginRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateTwoFactorLogin(); err != nil {
+		return
+	}
+
+	user, claims, err := service.parseChallenge(request.ChallengeToken)
+	if err != nil {
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		err = errors.New("two-factor enrollment required")
+		return
+	}
+
+	if err = service.verifySecondFactor(ctx, user, request.Code, request.RecoveryCode); err != nil {
+		return
+	}
+
+	// the first valid code after a login time enrollment confirms it
+	if !user.TOTPEnabled {
+		if err = service.repository.EnableTOTP(user.ID); err != nil {
+			return
+		}
+
+		auditSelf(ctx, audit.TwoFactorEnable, user, nil)
+	}
+
+	err = middlewares.Attempts.Lock(challengeUsedKey(claims.Id), time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		err = errors.New("unable to record challenge")
+		return
+	}
+
+	method := "2fa"
+	if !common.IsEmptyField(request.RecoveryCode) {
+		method = "recovery_code"
+	}
+
+	return service.completeLogin(ctx, user, method)
+}
+
+// EnrollTwoFactorLogin implements Service. It lets an owner who has to use
+// 2FA but has not enrolled yet do so in the middle of a login.
+func (service *UserService) EnrollTwoFactorLogin(ctx *gin.Context) (result TwoFactorEnrollment, err error) {
+	var request ChallengeRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateChallenge(); err != nil {
+		return
+	}
+
+	user, _, err := service.parseChallenge(request.ChallengeToken)
+	if err != nil {
+		return
+	}
+
+	if user.TOTPEnabled {
+		err = errors.New("two-factor authentication already enabled")
+		return
+	}
+
+	return service.newEnrollment(user)
+}
+
+// EnrollTwoFactor implements Service.
+func (service *UserService) EnrollTwoFactor(ctx *gin.Context) (result TwoFactorEnrollment, err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	user, err := service.repository.FindAccount(uint(loginData.UserId))
+	if err != nil {
+		return
+	}
+
+	if user.TOTPEnabled {
+		err = errors.New("two-factor authentication already enabled, disable it first")
+		return
+	}
+
+	return service.newEnrollment(user)
+}
+
+// ConfirmTwoFactor implements Service.
+func (service *UserService) ConfirmTwoFactor(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request TwoFactorCodeRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateCode(); err != nil {
+		return
+	}
+
+	user, err := service.repository.FindAccount(uint(loginData.UserId))
+	if err != nil {
+		return
+	}
+
+	if user.TOTPEnabled {
+		return errors.New("two-factor authentication already enabled")
+	}
+
+	if user.TOTPSecret == "" {
+		return errors.New("two-factor enrollment required")
+	}
+
+	if err = service.verifySecondFactor(ctx, user, request.Code, ""); err != nil {
+		return
+	}
+
+	if err = service.repository.EnableTOTP(user.ID); err != nil {
+		return
+	}
+
+	auditUser(ctx, audit.TwoFactorEnable, user, nil)
+
+	return nil
+}
+
+// DisableTwoFactor implements Service. A current code is required, and owners
+// cannot opt out while their organization requires 2FA.
+func (service *UserService) DisableTwoFactor(ctx *gin.Context) (err error) {
+	loginData, err := middlewares.GetLoginData(ctx)
+	if err != nil {
+		return
+	}
+
+	var request TwoFactorCodeRequest
+
+	if err = ctx.ShouldBind(&request); err != nil {
+		return
+	}
+
+	if err = request.ValidateCode(); err != nil {
+		return
+	}
+
+	user, err := service.repository.FindAccount(uint(loginData.UserId))
+	if err != nil {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	if user.Role == rbac.OwnerRole {
+		organization, err := service.repository.FindOrganization(user.OrganizationID)
+		if err != nil {
+			return errors.New("unable to load organization")
+		}
+
+		if organization.RequireOwnerTwoFactor {
+			return errors.New("two-factor authentication is required for owners of this organization")
+		}
+	}
+
+	if err = service.verifySecondFactor(ctx, user, request.Code, ""); err != nil {
+		return
+	}
+
+	if err = service.repository.DisableTOTP(user.ID); err != nil {
+		return
+	}
+
+	auditUser(ctx, audit.TwoFactorDisable, user, nil)
+
+	return nil
+}